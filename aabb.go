@@ -0,0 +1,140 @@
+package main
+
+import "math"
+
+// An axis-aligned bounding box
+type AABB struct {
+	min Vec3
+	max Vec3
+}
+
+// A box that contains nothing, so that any union with it returns the other box
+func EmptyAABB() AABB {
+	inf := math.Inf(1)
+	return AABB{
+		min: Vec3{inf, inf, inf},
+		max: Vec3{-inf, -inf, -inf},
+	}
+}
+
+// The smallest box that contains both boxes
+func (b AABB) Union(b2 AABB) AABB {
+	return AABB{min: b.min.Min(b2.min), max: b.max.Max(b2.max)}
+}
+
+// The box around the space inside both boxes, which is empty if they don't
+// overlap
+func (b AABB) Intersect(b2 AABB) AABB {
+	return AABB{min: b.min.Max(b2.min), max: b.max.Min(b2.max)}
+}
+
+// The smallest box that contains the box and the point
+func (b AABB) Extend(p Vec3) AABB {
+	return AABB{min: b.min.Min(p), max: b.max.Max(p)}
+}
+
+// Grow any side of the box thinner than delta, so that flat objects such as
+// axis-aligned triangles still have a box rays can hit
+func (b AABB) Pad(delta float64) AABB {
+	size := b.Size()
+	pad := Vec3{}
+	if size.x < delta {
+		pad.x = delta / 2
+	}
+	if size.y < delta {
+		pad.y = delta / 2
+	}
+	if size.z < delta {
+		pad.z = delta / 2
+	}
+
+	return AABB{min: b.min.Sub(pad), max: b.max.Add(pad)}
+}
+
+// Whether the box stops somewhere along every axis, unlike the box around
+// something that goes on forever
+func (b AABB) Bounded() bool {
+	for axis := 0; axis < 3; axis++ {
+		if math.IsInf(b.min.Axis(axis), -1) || math.IsInf(b.max.Axis(axis), 1) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (b AABB) Centroid() Vec3 {
+	return b.min.Add(b.max).Scale(0.5)
+}
+
+func (b AABB) Size() Vec3 {
+	return b.max.Sub(b.min)
+}
+
+// The total area of the six faces of the box
+func (b AABB) SurfaceArea() float64 {
+	size := b.Size()
+	if size.x < 0 || size.y < 0 || size.z < 0 {
+		return 0
+	}
+
+	return 2 * (size.x*size.y + size.y*size.z + size.z*size.x)
+}
+
+// The axis along which the box is longest
+func (b AABB) LongestAxis() int {
+	size := b.Size()
+	if size.x > size.y && size.x > size.z {
+		return 0
+	}
+	if size.y > size.z {
+		return 1
+	}
+	return 2
+}
+
+// Determine if the ray passes through the box within the interval using the
+// slab method
+func (b AABB) Hit(r Ray, itv Interval) bool {
+	_, ok := b.Clip(r, itv)
+	return ok
+}
+
+// The part of the interval where the ray is inside the box, or false if the
+// ray misses the box within the interval
+func (b AABB) Clip(r Ray, itv Interval) (Interval, bool) {
+	for axis := 0; axis < 3; axis++ {
+		direction := r.direction.Axis(axis)
+		origin := r.origin.Axis(axis)
+
+		// A ray parallel to the slab is inside it everywhere or nowhere, which
+		// the slab method can't tell where the ray starts on its edge, giving
+		// 0 * Inf
+		if direction == 0 {
+			if origin < b.min.Axis(axis) || origin > b.max.Axis(axis) {
+				return itv, false
+			}
+			continue
+		}
+
+		invDir := 1 / direction
+
+		t0 := (b.min.Axis(axis) - origin) * invDir
+		t1 := (b.max.Axis(axis) - origin) * invDir
+
+		// Make sure t0 is where the ray enters the slab
+		if invDir < 0 {
+			t0, t1 = t1, t0
+		}
+
+		// Shrink the interval to the part of the ray inside the slab
+		itv.min = math.Max(t0, itv.min)
+		itv.max = math.Min(t1, itv.max)
+
+		if itv.max <= itv.min {
+			return itv, false
+		}
+	}
+
+	return itv, true
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+// Settings for building the BVH with the surface area heuristic
+const (
+	bvhBuckets       = 16    // The number of candidate split planes tried per axis
+	bvhMaxLeafSize   = 4     // Leaves never hold more objects than this
+	bvhTraversalCost = 0.125 // The cost of visiting a node relative to hitting an object
+)
+
+// A node in the flattened BVH
+// Interior nodes have their first child directly after them and the second
+// child at secondChild, leaves hold count objects starting at first
+type bvhNode struct {
+	box         AABB
+	secondChild int
+	first       int
+	count       int
+	axis        int // The axis the children were split along
+}
+
+func (n bvhNode) IsLeaf() bool {
+	return n.count > 0
+}
+
+// A bounding volume hierarchy over a list of objects
+// Objects that go on forever, such as planes, can't be boxed, so they are kept
+// to one side and tested against every ray
+type BVH struct {
+	nodes     []bvhNode
+	objects   []Object
+	unbounded []Object
+	depth     int
+
+	// Traversal counters, only updated when stats are being collected
+	collectStats bool
+	rays         atomic.Int64
+	nodesVisited atomic.Int64
+}
+
+// Build a BVH over the objects using the surface area heuristic
+func NewBVH(objects []Object, collectStats bool) *BVH {
+	b := &BVH{
+		nodes:        make([]bvhNode, 0, 2*len(objects)),
+		objects:      make([]Object, 0, len(objects)),
+		collectStats: collectStats,
+	}
+
+	// Bounding boxes get reused a lot while building, so only ask for them once
+	boxes := make([]AABB, 0, len(objects))
+	for _, o := range objects {
+		box := o.BoundingBox()
+		if !box.Bounded() {
+			b.unbounded = append(b.unbounded, o)
+			continue
+		}
+
+		b.objects = append(b.objects, o)
+		boxes = append(boxes, box)
+	}
+
+	if len(b.objects) > 0 {
+		b.build(boxes, 0, len(b.objects), 1)
+	}
+
+	return b
+}
+
+// A list of objects and boxes that can be sorted together
+type bvhPrimitives struct {
+	objects []Object
+	boxes   []AABB
+	axis    int
+}
+
+func (p bvhPrimitives) Len() int {
+	return len(p.objects)
+}
+
+func (p bvhPrimitives) Less(i, j int) bool {
+	return p.boxes[i].Centroid().Axis(p.axis) < p.boxes[j].Centroid().Axis(p.axis)
+}
+
+func (p bvhPrimitives) Swap(i, j int) {
+	p.objects[i], p.objects[j] = p.objects[j], p.objects[i]
+	p.boxes[i], p.boxes[j] = p.boxes[j], p.boxes[i]
+}
+
+// Recursively build the nodes for objects[start:end], returning the index of
+// the node covering them
+func (b *BVH) build(boxes []AABB, start int, end int, depth int) int {
+	b.depth = max(b.depth, depth)
+
+	index := len(b.nodes)
+	b.nodes = append(b.nodes, bvhNode{})
+
+	// Bound everything in this node, and separately bound the centroids so
+	// that we know the range split planes can be placed in
+	box := EmptyAABB()
+	centroids := EmptyAABB()
+	for i := start; i < end; i++ {
+		box = box.Union(boxes[i])
+		centroids = centroids.Extend(boxes[i].Centroid())
+	}
+
+	count := end - start
+	b.nodes[index] = bvhNode{box: box, first: start, count: count}
+
+	if count == 1 {
+		return index
+	}
+
+	axis, mid := b.split(boxes, start, end, box, centroids)
+	if mid < 0 {
+		return index // Splitting costs more than testing every object
+	}
+
+	b.nodes[index].count = 0
+	b.nodes[index].axis = axis
+	b.build(boxes, start, mid, depth+1)
+	b.nodes[index].secondChild = b.build(boxes, mid, end, depth+1)
+
+	return index
+}
+
+// Choose where to split objects[start:end] using the surface area heuristic
+// Returns the axis and the index of the first object on the far side of the
+// split, or -1 if the objects should stay together in a leaf
+func (b *BVH) split(boxes []AABB, start int, end int, box AABB, centroids AABB) (int, int) {
+	count := end - start
+	axis := centroids.LongestAxis()
+
+	lo := centroids.min.Axis(axis)
+	extent := centroids.max.Axis(axis) - lo
+
+	// Every centroid is in the same place, so no plane can separate them
+	if extent <= 0 {
+		if count > bvhMaxLeafSize {
+			// Too many to keep in one leaf, so split them evenly instead
+			return axis, start + count/2
+		}
+		return axis, -1
+	}
+
+	// Drop every object into a bucket along the axis
+	type bucket struct {
+		count int
+		box   AABB
+	}
+	var buckets [bvhBuckets]bucket
+	for i := range buckets {
+		buckets[i].box = EmptyAABB()
+	}
+
+	bucketOf := func(box AABB) int {
+		i := int(bvhBuckets * (box.Centroid().Axis(axis) - lo) / extent)
+		return min(i, bvhBuckets-1)
+	}
+
+	for i := start; i < end; i++ {
+		bk := &buckets[bucketOf(boxes[i])]
+		bk.count++
+		bk.box = bk.box.Union(boxes[i])
+	}
+
+	// Sweep from the right to find the area and count behind every plane
+	var rightArea [bvhBuckets]float64
+	var rightCount [bvhBuckets]int
+	right := EmptyAABB()
+	n := 0
+	for i := bvhBuckets - 1; i > 0; i-- {
+		right = right.Union(buckets[i].box)
+		n += buckets[i].count
+		rightArea[i] = right.SurfaceArea()
+		rightCount[i] = n
+	}
+
+	// Sweep from the left, pricing each plane as we go
+	bestCost := float64(count) // The cost of making this node a leaf
+	bestPlane := -1
+	left := EmptyAABB()
+	n = 0
+	for i := 0; i < bvhBuckets-1; i++ {
+		left = left.Union(buckets[i].box)
+		n += buckets[i].count
+		if n == 0 || rightCount[i+1] == 0 {
+			continue
+		}
+
+		cost := bvhTraversalCost +
+			(left.SurfaceArea()*float64(n)+rightArea[i+1]*float64(rightCount[i+1]))/box.SurfaceArea()
+		if cost < bestCost {
+			bestCost = cost
+			bestPlane = i
+		}
+	}
+
+	if bestPlane < 0 && count <= bvhMaxLeafSize {
+		return axis, -1
+	}
+
+	// Leaves can't hold everything, so split even though it doesn't pay off
+	if bestPlane < 0 {
+		sort.Sort(bvhPrimitives{b.objects[start:end], boxes[start:end], axis})
+		return axis, start + count/2
+	}
+
+	// Move every object left of the plane to the front of the range
+	mid := start
+	for i := start; i < end; i++ {
+		if bucketOf(boxes[i]) <= bestPlane {
+			b.objects[i], b.objects[mid] = b.objects[mid], b.objects[i]
+			boxes[i], boxes[mid] = boxes[mid], boxes[i]
+			mid++
+		}
+	}
+
+	return axis, mid
+}
+
+// Find the closest hit on any object within the interval
+func (b *BVH) Hit(r Ray, itv Interval) (HitRecord, bool) {
+	var closest HitRecord
+	hitAnything := false
+
+	// Hitting the unbounded objects first lets them cull the tree
+	for _, o := range b.unbounded {
+		if rec, ok := o.Hit(r, itv); ok {
+			itv.max = rec.t
+			closest = rec
+			hitAnything = true
+		}
+	}
+
+	if len(b.nodes) == 0 {
+		return closest, hitAnything
+	}
+
+	// Nodes still to be visited
+	var stackBuffer [64]int
+	stack := append(stackBuffer[:0], 0)
+	visited := 0
+
+	for len(stack) > 0 {
+		index := stack[len(stack)-1]
+		node := b.nodes[index]
+		stack = stack[:len(stack)-1]
+		visited++
+
+		if !node.box.Hit(r, itv) {
+			continue
+		}
+
+		if node.IsLeaf() {
+			for _, o := range b.objects[node.first : node.first+node.count] {
+				// Check if there was a closer hit
+				if rec, ok := o.Hit(r, itv); ok {
+					itv.max = rec.t
+					closest = rec
+					hitAnything = true
+				}
+			}
+			continue
+		}
+
+		// Visit the nearer child first so that the farther one is more likely
+		// to be culled by the shrunk interval
+		first, second := index+1, node.secondChild
+		if r.direction.Axis(node.axis) < 0 {
+			first, second = second, first
+		}
+		stack = append(stack, second, first)
+	}
+
+	if b.collectStats {
+		b.rays.Add(1)
+		b.nodesVisited.Add(int64(visited))
+	}
+
+	return closest, hitAnything
+}
+
+// Describe the shape of the tree and how much work traversing it has taken
+func (b *BVH) Stats() string {
+	leaves := 0
+	for _, n := range b.nodes {
+		if n.IsLeaf() {
+			leaves++
+		}
+	}
+
+	stats := fmt.Sprintf("BVH: %d objects, %d nodes (%d leaves), depth %d",
+		len(b.objects), len(b.nodes), leaves, b.depth)
+
+	if len(b.unbounded) > 0 {
+		stats += fmt.Sprintf(", %d unbounded objects", len(b.unbounded))
+	}
+
+	if rays := b.rays.Load(); rays > 0 {
+		stats += fmt.Sprintf(", %.2f nodes visited per ray over %d rays",
+			float64(b.nodesVisited.Load())/float64(rays), rays)
+	}
+
+	return stats
+}
+
+// Forget the traversal counts so the next frame is measured on its own
+func (b *BVH) ResetStats() {
+	b.rays.Store(0)
+	b.nodesVisited.Store(0)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestClipParallelToSlab(t *testing.T) {
+	box := AABB{min: Vec3{0, 0, 0}, max: Vec3{1, 1, 1}}
+
+	tests := []struct {
+		name string
+		r    Ray
+		want Interval
+		hit  bool
+	}{
+		{"inside the slab", Ray{Vec3{0.5, 0.5, -1}, Vec3{0, 0, 1}}, Interval{1, 2}, true},
+		{"on the edge of the slab", Ray{Vec3{0, 0.5, -1}, Vec3{0, 0, 1}}, Interval{1, 2}, true},
+		{"on the corner of the box", Ray{Vec3{1, 1, -1}, Vec3{0, 0, 1}}, Interval{1, 2}, true},
+		{"beside the slab", Ray{Vec3{1.5, 0.5, -1}, Vec3{0, 0, 1}}, Interval{}, false},
+	}
+
+	for _, test := range tests {
+		got, ok := box.Clip(test.r, Interval{0, math.Inf(1)})
+		if ok != test.hit {
+			t.Errorf("%s: hit is %v, want %v", test.name, ok, test.hit)
+			continue
+		}
+		if ok && got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// The closest hit on any of the objects, trying every one of them
+func linearHit(objects []Object, r Ray, itv Interval) (HitRecord, bool) {
+	var closest HitRecord
+	hitAnything := false
+
+	for _, o := range objects {
+		if rec, ok := o.Hit(r, itv); ok {
+			itv.max = rec.t
+			closest = rec
+			hitAnything = true
+		}
+	}
+
+	return closest, hitAnything
+}
+
+func TestBVHMatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 0))
+	point := func(scale float64) Vec3 {
+		return Vec3{rng.Float64() - 0.5, rng.Float64() - 0.5, rng.Float64() - 0.5}.Scale(scale)
+	}
+
+	// Spheres and axis-aligned quads, whose boxes are nearly flat, on a grid
+	// so that axis-aligned rays run along the edges of their boxes
+	var objects []Object
+	for range 100 {
+		objects = append(objects, Sphere{point(20), 0.2 + rng.Float64(), nil})
+	}
+	for i := range 100 {
+		corner := point(20)
+		corner = Vec3{math.Round(corner.x), math.Round(corner.y), math.Round(corner.z)}
+		edges := [3]Vec3{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+		objects = append(objects, NewQuad(corner, edges[i%3], edges[(i+1)%3], nil))
+	}
+	objects = append(objects, NewPlane(Vec3{0, -15, 0}, Vec3{0, 1, 0}, nil))
+
+	bvh := NewBVH(objects, false)
+
+	for i := range 2000 {
+		r := Ray{point(30), point(2)}
+		if i%2 == 0 {
+			// Axis-aligned rays starting on whole numbers
+			r.origin = Vec3{math.Round(r.origin.x), math.Round(r.origin.y), math.Round(r.origin.z)}
+			r.direction = [3]Vec3{{1, 0, 0}, {0, -1, 0}, {0, 0, 1}}[i%3]
+		}
+		itv := Interval{0.0001, math.Inf(1)}
+
+		want, wantOk := linearHit(objects, r, itv)
+		got, gotOk := bvh.Hit(r, itv)
+		if gotOk != wantOk || got.t != want.t || got.object != want.object {
+			t.Fatalf("ray %v: BVH hit %v at %v, linear scan hit %v at %v", r, gotOk, got.t, wantOk, want.t)
+		}
+	}
+}
@@ -1,8 +1,23 @@
 package main
 
+import (
+	"math"
+	"math/rand/v2"
+)
+
 type Camera struct {
 	position       Vec3
-	focalLength    float64
+	lookAt         Vec3
+	up             Vec3
+	vfov           float64 // Vertical field of view in degrees
+	u              Vec3    // Unit vector pointing to the camera's right
+	v              Vec3    // Unit vector pointing up from the camera's point of view
+	w              Vec3    // Unit vector pointing opposite the view direction
+	focalLength    float64 // The distance to the viewport, which is the plane in perfect focus
+	defocusAngle   float64 // The angle of the cone of rays through each pixel in degrees, 0 for no blur
+	apertureBlades int     // The number of sides on the aperture, 0 for a perfect circle
+	defocusDiskU   Vec3    // The horizontal radius of the lens
+	defocusDiskV   Vec3    // The vertical radius of the lens
 	viewportHeight float64
 	viewportWidth  float64
 	viewportX      Vec3
@@ -13,5 +28,55 @@ type Camera struct {
 }
 
 func (c Camera) TopLeft() Vec3 {
-	return c.position.Sub(Vec3{0, 0, c.focalLength}).Sub(c.viewportX.Div(2)).Sub(c.viewportY.Div(2))
+	return c.position.Sub(c.w.Scale(c.focalLength)).Sub(c.viewportX.Div(2)).Sub(c.viewportY.Div(2))
+}
+
+func degreesToRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+// Pick where on the lens a ray should start from
+func (c Camera) RayOrigin(rng *rand.Rand) Vec3 {
+	if c.defocusAngle <= 0 {
+		return c.position
+	}
+
+	var x, y float64
+	if c.apertureBlades >= 3 {
+		x, y = randomInPolygon(rng, c.apertureBlades)
+	} else {
+		x, y = randomInUnitDisk(rng)
+	}
+
+	return c.position.Add(c.defocusDiskU.Scale(x)).Add(c.defocusDiskV.Scale(y))
+}
+
+// Generate a uniformly distributed random point on the unit disk
+func randomInUnitDisk(rng *rand.Rand) (float64, float64) {
+	// Taking the square root of the radius stops the points bunching up in the
+	// middle of the disk
+	r := math.Sqrt(rng.Float64())
+	theta := 2 * math.Pi * rng.Float64()
+
+	return r * math.Cos(theta), r * math.Sin(theta)
+}
+
+// Generate a uniformly distributed random point inside a regular polygon
+// whose corners lie on the unit circle, with one corner pointing straight up
+func randomInPolygon(rng *rand.Rand, sides int) (float64, float64) {
+	// The polygon is a fan of identical triangles around the center, so pick
+	// one of them and then a point inside it
+	wedge := 2 * math.Pi / float64(sides)
+	start := math.Pi/2 + wedge*float64(rng.IntN(sides))
+
+	// Fold the unit square onto the triangle to keep the points uniform
+	a, b := rng.Float64(), rng.Float64()
+	if a+b > 1 {
+		a, b = 1-a, 1-b
+	}
+
+	x := a*math.Cos(start) + b*math.Cos(start+wedge)
+	y := a*math.Sin(start) + b*math.Sin(start+wedge)
+
+	return x, y
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+// The ways two solids can be combined
+type CSGOperation int
+
+const (
+	CSGUnion        CSGOperation = iota // Inside either solid
+	CSGIntersection                     // Inside both solids
+	CSGDifference                       // Inside the first solid but not the second
+)
+
+// The operations that can be picked by name
+var csgOperations = map[string]CSGOperation{
+	"union":        CSGUnion,
+	"intersection": CSGIntersection,
+	"difference":   CSGDifference,
+}
+
+// Implements Object interface
+// Implements Solid interface
+// Two solids combined into one by constructive solid geometry. Every part of
+// the surface keeps the material of the solid it came from, so the hole a
+// difference cuts is lined with the second solid's material
+// Either solid can be another combination, building up trees of them
+type CSG struct {
+	operation CSGOperation
+	a         Solid
+	b         Solid
+}
+
+// The combination of two objects, or false if either of them doesn't enclose
+// a space
+func NewCSG(operation CSGOperation, a Object, b Object) (CSG, bool) {
+	solidA, okA := asSolid(a)
+	solidB, okB := asSolid(b)
+	if !okA || !okB {
+		return CSG{}, false
+	}
+
+	return CSG{operation: operation, a: solidA, b: solidB}, true
+}
+
+// The object as a solid if it encloses a space. Transformed objects can always
+// list spans, but only have any when the object inside them is a solid
+func asSolid(o Object) (Solid, bool) {
+	if t, ok := o.(Transformed); ok {
+		if _, ok := asSolid(t.object); !ok {
+			return nil, false
+		}
+	}
+
+	solid, ok := o.(Solid)
+	return solid, ok
+}
+
+func (c CSG) Center() Vec3 {
+	return c.BoundingBox().Centroid()
+}
+
+// Only the parts of the solids that can be left after combining them are
+// bounded, so a difference is never bigger than its first solid
+func (c CSG) BoundingBox() AABB {
+	switch c.operation {
+	case CSGUnion:
+		return c.a.BoundingBox().Union(c.b.BoundingBox())
+	case CSGIntersection:
+		return c.a.BoundingBox().Intersect(c.b.BoundingBox())
+	default:
+		return c.a.BoundingBox()
+	}
+}
+
+// Only the crossings within the interval are looked for, so the first span
+// found holds the nearest hit
+func (c CSG) Hit(r Ray, itv Interval) (HitRecord, bool) {
+	for _, span := range c.Spans(r, itv) {
+		for _, rec := range [2]HitRecord{span.in, span.out} {
+			if itv.Contains(rec.t) {
+				// The combination stands in for the primitive, which on its own
+				// might be sampled as a light where it's been cut away
+				rec.object = c
+				return rec, true
+			}
+		}
+	}
+
+	return HitRecord{}, false
+}
+
+// Whether a point is in the combination only depends on whether it's in each
+// solid, so the solids' spans within the interval are all that's needed
+func (c CSG) Spans(r Ray, itv Interval) []Span {
+	a := c.a.Spans(r, itv)
+
+	// Nothing can be left of an intersection or a difference where the ray
+	// misses the first solid
+	if len(a) == 0 && c.operation != CSGUnion {
+		return nil
+	}
+
+	b := c.b.Spans(r, itv)
+
+	switch c.operation {
+	case CSGUnion:
+		return combineSpans(a, b, func(inA bool, inB bool) bool { return inA || inB })
+	case CSGIntersection:
+		return combineSpans(a, b, func(inA bool, inB bool) bool { return inA && inB })
+	default:
+		// Cutting away the second solid leaves what's inside the first but
+		// outside the second, where the cut's surface faces into the second
+		return combineSpans(a, complementSpans(b), func(inA bool, inB bool) bool { return inA && inB })
+	}
+}
+
+// Walk along the ray through everywhere either list of spans starts or ends,
+// keeping the stretches where keep says a point inside or outside each of them
+// belongs to the combination
+func combineSpans(a []Span, b []Span, keep func(inA bool, inB bool) bool) []Span {
+	type crossing struct {
+		rec      HitRecord
+		solid    int
+		entering bool
+	}
+
+	crossings := make([]crossing, 0, 2*(len(a)+len(b)))
+	for solid, spans := range [2][]Span{a, b} {
+		for _, span := range spans {
+			crossings = append(crossings, crossing{span.in, solid, true}, crossing{span.out, solid, false})
+		}
+	}
+	slices.SortStableFunc(crossings, func(x crossing, y crossing) int {
+		return cmp.Compare(x.rec.t, y.rec.t)
+	})
+
+	var spans []Span
+	var inside [2]bool
+	var entry HitRecord
+
+	for _, c := range crossings {
+		wasInside := keep(inside[0], inside[1])
+		inside[c.solid] = c.entering
+		isInside := keep(inside[0], inside[1])
+
+		switch {
+		case isInside && !wasInside:
+			entry = c.rec
+		case wasInside && !isInside:
+			spans = append(spans, Span{in: entry, out: c.rec})
+		}
+	}
+
+	return spans
+}
+
+// The stretches of the ray outside the spans, as if the solid were turned
+// inside out
+func complementSpans(spans []Span) []Span {
+	outside := make([]Span, 0, len(spans)+1)
+	in := HitRecord{t: math.Inf(-1)}
+
+	for _, span := range spans {
+		if !math.IsInf(span.in.t, -1) {
+			outside = append(outside, Span{in: in, out: turnedInsideOut(span.in)})
+		}
+		in = turnedInsideOut(span.out)
+	}
+
+	if !math.IsInf(in.t, 1) {
+		outside = append(outside, Span{in: in, out: HitRecord{t: math.Inf(1)}})
+	}
+
+	return outside
+}
+
+// The hit with its normals facing the other way. The u direction is reversed
+// too, keeping the tangent frame right-handed about the flipped normal so that
+// normal and bump maps still shade it the right way round
+func turnedInsideOut(rec HitRecord) HitRecord {
+	rec.normal = rec.normal.Scale(-1)
+	rec.geometricNormal = rec.geometricNormal.Scale(-1)
+	rec.dpdu = rec.dpdu.Scale(-1)
+	return rec
+}
+
+// The spans of a closed object within the interval, found by following the
+// ray from one hit to the next, going in where the surface faces the ray and
+// out where it faces away
+// Hits that would go in while already inside, or out while already outside,
+// are where the ray grazes a seam between two parts of the surface, and are
+// skipped
+func crossingSpans(o Object, r Ray, itv Interval) []Span {
+	var spans []Span
+	var entry HitRecord
+	inside := false
+	first := true
+
+	search := itv
+	for {
+		rec, ok := o.Hit(r, search)
+		if !ok {
+			break
+		}
+		search.min = math.Nextafter(rec.t, math.Inf(1))
+
+		entering := rec.geometricNormal.Dot(r.direction) < 0
+		switch {
+		case entering && !inside:
+			entry, inside = rec, true
+		case !entering && inside:
+			spans = append(spans, Span{in: entry, out: rec})
+			inside = false
+		case !entering && first:
+			// The ray went in before the interval
+			spans = append(spans, Span{in: HitRecord{t: math.Inf(-1)}, out: rec})
+		}
+		first = false
+	}
+
+	if inside {
+		spans = append(spans, Span{in: entry, out: HitRecord{t: math.Inf(1)}})
+	}
+
+	// Without any crossings in the interval, the ray is either inside the
+	// whole way or outside it, which the next crossing after it tells
+	if first {
+		if rec, ok := o.Hit(r, Interval{itv.max, math.Inf(1)}); ok && rec.geometricNormal.Dot(r.direction) >= 0 {
+			spans = append(spans, Span{in: HitRecord{t: math.Inf(-1)}, out: HitRecord{t: math.Inf(1)}})
+		}
+	}
+
+	return spans
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+var (
+	union        = func(inA bool, inB bool) bool { return inA || inB }
+	intersection = func(inA bool, inB bool) bool { return inA && inB }
+)
+
+// Spans along a ray heading along +X, with normals facing out of them
+func spansAlongX(ends ...[2]float64) []Span {
+	spans := make([]Span, len(ends))
+	for i, end := range ends {
+		spans[i] = Span{
+			in:  HitRecord{t: end[0], normal: Vec3{-1, 0, 0}, geometricNormal: Vec3{-1, 0, 0}, dpdu: Vec3{0, 0, 1}},
+			out: HitRecord{t: end[1], normal: Vec3{1, 0, 0}, geometricNormal: Vec3{1, 0, 0}, dpdu: Vec3{0, 0, -1}},
+		}
+	}
+
+	return spans
+}
+
+func spanEnds(spans []Span) [][2]float64 {
+	ends := make([][2]float64, len(spans))
+	for i, span := range spans {
+		ends[i] = [2]float64{span.in.t, span.out.t}
+	}
+
+	return ends
+}
+
+func endsEqual(a [][2]float64, b [][2]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestCombineSpans(t *testing.T) {
+	inf := math.Inf(1)
+
+	tests := []struct {
+		name      string
+		a, b      [][2]float64
+		union     [][2]float64
+		intersect [][2]float64
+		minus     [][2]float64
+	}{
+		{
+			name:      "overlapping",
+			a:         [][2]float64{{1, 4}},
+			b:         [][2]float64{{3, 6}},
+			union:     [][2]float64{{1, 6}},
+			intersect: [][2]float64{{3, 4}},
+			minus:     [][2]float64{{1, 3}},
+		},
+		{
+			name:      "apart",
+			a:         [][2]float64{{1, 2}},
+			b:         [][2]float64{{3, 4}},
+			union:     [][2]float64{{1, 2}, {3, 4}},
+			intersect: [][2]float64{},
+			minus:     [][2]float64{{1, 2}},
+		},
+		{
+			name:      "touching",
+			a:         [][2]float64{{1, 2}},
+			b:         [][2]float64{{2, 3}},
+			union:     [][2]float64{{1, 2}, {2, 3}},
+			intersect: [][2]float64{},
+			minus:     [][2]float64{{1, 2}},
+		},
+		{
+			name:      "nested",
+			a:         [][2]float64{{1, 6}},
+			b:         [][2]float64{{2, 3}},
+			union:     [][2]float64{{1, 6}},
+			intersect: [][2]float64{{2, 3}},
+			minus:     [][2]float64{{1, 2}, {3, 6}},
+		},
+		{
+			name:      "second around the first",
+			a:         [][2]float64{{2, 3}},
+			b:         [][2]float64{{1, 6}},
+			union:     [][2]float64{{1, 6}},
+			intersect: [][2]float64{{2, 3}},
+			minus:     [][2]float64{},
+		},
+		{
+			name:      "several spans",
+			a:         [][2]float64{{1, 3}, {5, 7}, {9, 11}},
+			b:         [][2]float64{{2, 6}, {10, 12}},
+			union:     [][2]float64{{1, 7}, {9, 12}},
+			intersect: [][2]float64{{2, 3}, {5, 6}, {10, 11}},
+			minus:     [][2]float64{{1, 2}, {6, 7}, {9, 10}},
+		},
+		{
+			name:      "going on forever",
+			a:         [][2]float64{{-inf, 4}},
+			b:         [][2]float64{{2, inf}},
+			union:     [][2]float64{{-inf, inf}},
+			intersect: [][2]float64{{2, 4}},
+			minus:     [][2]float64{{-inf, 2}},
+		},
+	}
+
+	for _, test := range tests {
+		a, b := spansAlongX(test.a...), spansAlongX(test.b...)
+
+		if got := spanEnds(combineSpans(a, b, union)); !endsEqual(got, test.union) {
+			t.Errorf("%s union: got %v, want %v", test.name, got, test.union)
+		}
+		if got := spanEnds(combineSpans(a, b, intersection)); !endsEqual(got, test.intersect) {
+			t.Errorf("%s intersection: got %v, want %v", test.name, got, test.intersect)
+		}
+		if got := spanEnds(combineSpans(a, complementSpans(b), intersection)); !endsEqual(got, test.minus) {
+			t.Errorf("%s difference: got %v, want %v", test.name, got, test.minus)
+		}
+	}
+}
+
+func TestComplementSpans(t *testing.T) {
+	inf := math.Inf(1)
+
+	tests := []struct {
+		spans [][2]float64
+		want  [][2]float64
+	}{
+		{nil, [][2]float64{{-inf, inf}}},
+		{[][2]float64{{1, 2}}, [][2]float64{{-inf, 1}, {2, inf}}},
+		{[][2]float64{{1, 2}, {3, 4}}, [][2]float64{{-inf, 1}, {2, 3}, {4, inf}}},
+		{[][2]float64{{-inf, 2}}, [][2]float64{{2, inf}}},
+		{[][2]float64{{1, inf}}, [][2]float64{{-inf, 1}}},
+		{[][2]float64{{-inf, inf}}, [][2]float64{}},
+	}
+
+	for _, test := range tests {
+		if got := spanEnds(complementSpans(spansAlongX(test.spans...))); !endsEqual(got, test.want) {
+			t.Errorf("complement of %v: got %v, want %v", test.spans, got, test.want)
+		}
+	}
+
+	// The surfaces of the complement face into the original spans, with the
+	// tangent frame turned to match
+	outside := complementSpans(spansAlongX([2]float64{1, 2}))
+	cut := outside[0].out
+	if cut.geometricNormal != (Vec3{1, 0, 0}) || cut.normal != (Vec3{1, 0, 0}) {
+		t.Errorf("complement normal: got %v, want %v", cut.geometricNormal, Vec3{1, 0, 0})
+	}
+	if cut.dpdu != (Vec3{0, 0, -1}) {
+		t.Errorf("complement dpdu: got %v, want %v", cut.dpdu, Vec3{0, 0, -1})
+	}
+}
+
+func TestCSGHit(t *testing.T) {
+	a := Sphere{Vec3{0, 0, 0}, 1, nil}
+	b := Sphere{Vec3{1, 0, 0}, 1, nil}
+	r := Ray{Vec3{-5, 0, 0}, Vec3{1, 0, 0}}
+
+	tests := []struct {
+		name      string
+		operation CSGOperation
+		itv       Interval
+		want      float64
+		normal    Vec3
+	}{
+		{"union", CSGUnion, Interval{0, math.Inf(1)}, 4, Vec3{-1, 0, 0}},
+		{"intersection", CSGIntersection, Interval{0, math.Inf(1)}, 5, Vec3{-1, 0, 0}},
+		{"difference", CSGDifference, Interval{0, math.Inf(1)}, 4, Vec3{-1, 0, 0}},
+
+		// Starting inside the first sphere, the cut into the second is the
+		// nearest surface even though the interval never leaves the first
+		{"difference from inside", CSGDifference, Interval{4.5, 5.5}, 5, Vec3{1, 0, 0}},
+		{"union from inside", CSGUnion, Interval{4.5, 7.5}, 7, Vec3{1, 0, 0}},
+	}
+
+	for _, test := range tests {
+		csg, ok := NewCSG(test.operation, a, b)
+		if !ok {
+			t.Fatalf("%s: spheres should combine", test.name)
+		}
+
+		rec, ok := csg.Hit(r, test.itv)
+		if !ok {
+			t.Errorf("%s: missed", test.name)
+			continue
+		}
+		if math.Abs(rec.t-test.want) > 1e-9 || rec.geometricNormal.Sub(test.normal).Length() > 1e-9 {
+			t.Errorf("%s: hit at %v facing %v, want %v facing %v", test.name, rec.t, rec.geometricNormal, test.want, test.normal)
+		}
+	}
+
+	// A stretch wholly inside the intersection has no surface to hit
+	csg, _ := NewCSG(CSGIntersection, a, b)
+	if rec, ok := csg.Hit(r, Interval{5.2, 5.8}); ok {
+		t.Errorf("hit inside the intersection at %v", rec.t)
+	}
+}
+
+func TestNestedCSG(t *testing.T) {
+	// A cube with a hole along X, turned and moved by a transform
+	cube := NewBox(Vec3{-1, -1, -1}, Vec3{1, 1, 1}, nil)
+	hole := NewCylinder(Vec3{-2, 0, 0}, Vec3{1, 0, 0}, 0.5, 4, nil)
+	holed, _ := NewCSG(CSGDifference, cube, hole)
+
+	transform, _ := NewTransform(TranslationMatrix(Vec3{10, 0, 0}).Mul(ScaleMatrix(Vec3{2, 2, 2})))
+	moved := NewTransformed(holed, transform)
+
+	// Filling the hole back in with a smaller rod
+	rod := Sphere{Vec3{10, 0, 0}, 0.5, nil}
+	filled, ok := NewCSG(CSGUnion, moved, rod)
+	if !ok {
+		t.Fatal("transformed combinations should combine")
+	}
+
+	// Down the hole, only the rod is in the way
+	r := Ray{Vec3{0, 0, 0}, Vec3{1, 0, 0}}
+	got := spanEnds(filled.Spans(r, Interval{0, math.Inf(1)}))
+	want := [][2]float64{{9.5, 10.5}}
+	if !endsEqual(got, want) {
+		t.Errorf("down the hole: got %v, want %v", got, want)
+	}
+
+	// Beside the hole, the ray goes through the whole cube
+	r = Ray{Vec3{0, 1.5, 0}, Vec3{1, 0, 0}}
+	got = spanEnds(filled.Spans(r, Interval{0, math.Inf(1)}))
+	want = [][2]float64{{8, 12}}
+	if !endsEqual(got, want) {
+		t.Errorf("beside the hole: got %v, want %v", got, want)
+	}
+}
+
+func TestNewCSGNeedsSolids(t *testing.T) {
+	sphere := Sphere{Vec3{0, 0, 0}, 1, nil}
+	quad := NewQuad(Vec3{0, 0, 0}, Vec3{1, 0, 0}, Vec3{0, 1, 0}, nil)
+	transform, _ := NewTransform(IdentityMatrix())
+
+	if _, ok := NewCSG(CSGDifference, sphere, quad); ok {
+		t.Error("combined a quad")
+	}
+	if _, ok := NewCSG(CSGDifference, sphere, NewTransformed(quad, transform)); ok {
+		t.Error("combined a transformed quad")
+	}
+	if _, ok := NewCSG(CSGDifference, sphere, NewTransformed(sphere, transform)); !ok {
+		t.Error("didn't combine a transformed sphere")
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import "math"
+
+// A local space for shapes built around an axis, with the origin at the base
+// of the shape and the axis along +Y, so their equations stay simple
+type axisFrame struct {
+	origin Vec3
+	x      Vec3
+	y      Vec3
+	z      Vec3
+}
+
+func newAxisFrame(origin Vec3, axis Vec3) axisFrame {
+	y := axis.Unit()
+
+	// Keep x as close to +X as the axis allows, so that shapes standing
+	// upright line their textures up with spheres'
+	reference := Vec3{1, 0, 0}
+	if math.Abs(y.x) > 0.9 {
+		reference = Vec3{0, 0, -1}
+	}
+	x := reference.Sub(y.Scale(reference.Dot(y))).Unit()
+
+	return axisFrame{origin: origin, x: x, y: y, z: x.Cross(y)}
+}
+
+// The ray in local space, which keeps the same distances along it
+func (f axisFrame) localRay(r Ray) Ray {
+	return Ray{
+		origin:    r.origin.Sub(f.origin).ToBasis(f.x, f.y, f.z),
+		direction: r.direction.ToBasis(f.x, f.y, f.z),
+	}
+}
+
+// A direction in local space turned back into world space
+func (f axisFrame) toWorld(v Vec3) Vec3 {
+	return v.FromBasis(f.x, f.y, f.z)
+}
+
+// Describe a hit found in local space in world space
+func (f axisFrame) hitRecord(r Ray, t float64, normal Vec3, u float64, v float64, dpdu Vec3, dpdv Vec3, material Material, object Object) HitRecord {
+	normal = f.toWorld(normal).Unit()
+
+	return HitRecord{
+		t:               t,
+		point:           r.At(t),
+		normal:          normal,
+		geometricNormal: normal,
+		u:               u,
+		v:               v,
+		dpdu:            f.toWorld(dpdu),
+		dpdv:            f.toWorld(dpdv),
+		material:        material,
+		object:          object,
+	}
+}
+
+// How far around the axis a local point is, from 0 to 1 starting from -X, the
+// same way a sphere's texture wraps around
+func aroundAxis(p Vec3) float64 {
+	return (math.Atan2(-p.z, p.x) + math.Pi) / (2 * math.Pi)
+}
+
+// How a local point moves as it goes around the axis once
+func aroundAxisDerivative(p Vec3) Vec3 {
+	return Vec3{p.z, 0, -p.x}.Scale(2 * math.Pi)
+}
+
+// Implements Object interface
+// Implements Solid interface
+// A capped cylinder standing on its base along its axis. The radius can
+// narrow from the base to the top, making a cone when the top comes to a point
+// Texture coordinates wrap around the side the same way as a sphere's, with v
+// going up the side, while the caps are textured as if stuck on flat
+type Cylinder struct {
+	frame        axisFrame
+	bottomRadius float64
+	topRadius    float64
+	height       float64
+	material     Material
+}
+
+// A cylinder the same width all the way up
+func NewCylinder(base Vec3, axis Vec3, radius float64, height float64, material Material) Cylinder {
+	return NewCone(base, axis, radius, radius, height, material)
+}
+
+// A cylinder whose radius changes from the base to the top
+func NewCone(base Vec3, axis Vec3, bottomRadius float64, topRadius float64, height float64, material Material) Cylinder {
+	return Cylinder{
+		frame:        newAxisFrame(base, axis),
+		bottomRadius: bottomRadius,
+		topRadius:    topRadius,
+		height:       height,
+		material:     material,
+	}
+}
+
+func (c Cylinder) Center() Vec3 {
+	return c.frame.origin.Add(c.frame.y.Scale(c.height / 2))
+}
+
+// The box around both ends, which are disks
+func (c Cylinder) BoundingBox() AABB {
+	bottom := NewDisk(c.frame.origin, c.frame.y, c.bottomRadius, nil)
+	top := NewDisk(c.frame.origin.Add(c.frame.y.Scale(c.height)), c.frame.y, c.topRadius, nil)
+
+	return bottom.BoundingBox().Union(top.BoundingBox())
+}
+
+func (c Cylinder) Hit(r Ray, itv Interval) (HitRecord, bool) {
+	local := c.frame.localRay(r)
+	o, d := local.origin, local.direction
+
+	// Solve the side along a unit direction, since transformed rays can have
+	// directions of any length
+	length := d.Length()
+	unit := d.Div(length)
+
+	// The radius grows by slope for every unit up the axis
+	slope := (c.topRadius - c.bottomRadius) / c.height
+	radiusAt := func(y float64) float64 {
+		return c.bottomRadius + slope*y
+	}
+
+	var record HitRecord
+	hitAnything := false
+
+	// The side is where x² + z² = radius(y)²
+	rad := radiusAt(o.y)
+	a := unit.x*unit.x + unit.z*unit.z - slope*slope*unit.y*unit.y
+	b := 2 * (o.x*unit.x + o.z*unit.z - slope*rad*unit.y)
+	cc := o.x*o.x + o.z*o.z - rad*rad
+	for _, root := range solveQuadratic(a, b, cc) {
+		t := root / length
+		p := local.At(t)
+		if !itv.Contains(t) || p.y < 0 || p.y > c.height || radiusAt(p.y) < 0 {
+			continue
+		}
+
+		normal := Vec3{p.x, -slope * radiusAt(p.y), p.z}
+		if normal.LengthSquared() == 0 {
+			normal = Vec3{0, 1, 0} // The tip of a cone
+		}
+
+		// Moving up the side also moves in or out with the radius
+		dpdv := Vec3{0, c.height, 0}
+		if radius := radiusAt(p.y); radius > 0 {
+			dpdv = Vec3{p.x / radius * slope * c.height, c.height, p.z / radius * slope * c.height}
+		}
+
+		record = c.frame.hitRecord(r, t, normal, aroundAxis(p), p.y/c.height, aroundAxisDerivative(p), dpdv, c.material, c)
+		itv.max = t
+		hitAnything = true
+		break
+	}
+
+	// The caps are disks at each end
+	for _, end := range []struct {
+		y      float64
+		radius float64
+		normal Vec3
+	}{
+		{0, c.bottomRadius, Vec3{0, -1, 0}},
+		{c.height, c.topRadius, Vec3{0, 1, 0}},
+	} {
+		if end.radius <= 0 || d.y == 0 {
+			continue
+		}
+
+		t := (end.y - o.y) / d.y
+		p := local.At(t)
+		if !itv.Contains(t) || p.x*p.x+p.z*p.z > end.radius*end.radius {
+			continue
+		}
+
+		// Flip v on the top cap so both caps' textures read the right way
+		// round from outside
+		diameter := 2 * end.radius
+		u := 0.5 + p.x/diameter
+		v := 0.5 - end.normal.y*p.z/diameter
+		record = c.frame.hitRecord(r, t, end.normal, u, v, Vec3{diameter, 0, 0}, Vec3{0, 0, -end.normal.y * diameter}, c.material, c)
+		itv.max = t
+		hitAnything = true
+	}
+
+	return record, hitAnything
+}
+
+func (c Cylinder) Spans(r Ray, itv Interval) []Span {
+	return crossingSpans(c, r, itv)
+}
+
+// Implements Object interface
+// Implements Solid interface
+// A cylinder with a hemisphere on each end, the shape of everything within
+// radius of the line from its base up its axis for height units
+// Texture coordinates wrap around the axis the same way as a sphere's, with v
+// going from the bottom pole to the top one
+type Capsule struct {
+	frame    axisFrame
+	radius   float64
+	height   float64
+	material Material
+}
+
+func NewCapsule(base Vec3, axis Vec3, radius float64, height float64, material Material) Capsule {
+	return Capsule{
+		frame:    newAxisFrame(base, axis),
+		radius:   radius,
+		height:   height,
+		material: material,
+	}
+}
+
+func (c Capsule) Center() Vec3 {
+	return c.frame.origin.Add(c.frame.y.Scale(c.height / 2))
+}
+
+// The box around both ends, grown by the radius in every direction
+func (c Capsule) BoundingBox() AABB {
+	extent := Vec3{c.radius, c.radius, c.radius}
+	top := c.frame.origin.Add(c.frame.y.Scale(c.height))
+
+	return EmptyAABB().
+		Extend(c.frame.origin.Sub(extent)).
+		Extend(c.frame.origin.Add(extent)).
+		Extend(top.Sub(extent)).
+		Extend(top.Add(extent))
+}
+
+func (c Capsule) Hit(r Ray, itv Interval) (HitRecord, bool) {
+	local := c.frame.localRay(r)
+	o, d := local.origin, local.direction
+
+	var closest float64
+	var center float64 // Where along the axis the nearest point to the hit is
+	hitAnything := false
+
+	// Solve along a unit direction, since transformed rays can have directions
+	// of any length
+	length := d.Length()
+	unit := d.Div(length)
+
+	// The side, where x² + z² = radius² between the ends
+	a := unit.x*unit.x + unit.z*unit.z
+	b := 2 * (o.x*unit.x + o.z*unit.z)
+	cc := o.x*o.x + o.z*o.z - c.radius*c.radius
+	for _, root := range solveQuadratic(a, b, cc) {
+		t := root / length
+		if y := o.y + t*d.y; itv.Contains(t) && y >= 0 && y <= c.height {
+			closest, center = t, y
+			itv.max = t
+			hitAnything = true
+			break
+		}
+	}
+
+	// The hemispheres, where only the half beyond each end is part of the
+	// surface
+	for _, end := range []float64{0, c.height} {
+		toEnd := o.Sub(Vec3{0, end, 0})
+		for _, root := range solveQuadratic(1, 2*toEnd.Dot(unit), toEnd.LengthSquared()-c.radius*c.radius) {
+			t := root / length
+			y := o.y + t*d.y
+			if !itv.Contains(t) || (end == 0 && y > 0) || (end > 0 && y < c.height) {
+				continue
+			}
+
+			closest, center = t, end
+			itv.max = t
+			hitAnything = true
+			break
+		}
+	}
+
+	if !hitAnything {
+		return HitRecord{}, false
+	}
+
+	p := local.At(closest)
+	normal := p.Sub(Vec3{0, center, 0}).Div(c.radius)
+
+	// v runs up the capsule's full length, pole to pole
+	extent := c.height + 2*c.radius
+	v := (p.y + c.radius) / extent
+
+	// Moving up a hemisphere also moves in or out around the axis
+	dpdv := Vec3{0, extent, 0}
+	if ring := math.Sqrt(p.x*p.x + p.z*p.z); p.y != center && ring > 1e-9 {
+		inward := -(p.y - center) / ring
+		dpdv = Vec3{inward * p.x / ring, 1, inward * p.z / ring}.Scale(extent)
+	}
+
+	return c.frame.hitRecord(r, closest, normal, aroundAxis(p), v, aroundAxisDerivative(p), dpdv, c.material, c), true
+}
+
+func (c Capsule) Spans(r Ray, itv Interval) []Span {
+	return crossingSpans(c, r, itv)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+)
+
+// Implements Light interface
+// An equirectangular image wrapped around the whole scene, lighting it from
+// infinitely far away. The top row of the image is straight up (+Y), and the
+// middle of the image faces +X before being turned by the rotation
+// Directions are picked in proportion to the brightness of the image, so small
+// bright features like the sun are found without waiting for a bounce to hit
+type Environment struct {
+	image     *ImageTexture
+	rotation  float64 // Radians to turn the image anticlockwise about +Y
+	intensity float64
+
+	rowCDF    []float64 // The chance of picking each row or any row above it
+	columnCDF []float64 // Within each row, the same for each pixel
+	rowWeight []float64 // The total weight of each row
+	total     float64
+}
+
+// Wrap an image around the scene, ready to be sampled
+func NewEnvironment(image *ImageTexture, rotation float64, intensity float64) *Environment {
+	e := &Environment{
+		image:     image,
+		rotation:  rotation,
+		intensity: intensity,
+		rowCDF:    make([]float64, image.height),
+		columnCDF: make([]float64, image.width*image.height),
+		rowWeight: make([]float64, image.height),
+	}
+
+	// Each pixel is weighted by its brightness and by how much of the sphere
+	// it covers, which shrinks towards the poles
+	for y := range image.height {
+		sinTheta := math.Sin((float64(y) + 0.5) / float64(image.height) * math.Pi)
+
+		rowTotal := 0.0
+		for x := range image.width {
+			rowTotal += math.Max(0, luminance(image.pixels[y*image.width+x])) * sinTheta
+			e.columnCDF[y*image.width+x] = rowTotal
+		}
+
+		e.rowWeight[y] = rowTotal
+		e.total += rowTotal
+		e.rowCDF[y] = e.total
+	}
+
+	return e
+}
+
+// The light arriving from a direction
+func (e *Environment) Radiance(direction Vec3) Vec3 {
+	x, y := e.pixelAt(direction)
+	return e.image.pixels[y*e.image.width+x].Scale(e.intensity)
+}
+
+// The pixel of the image a direction looks at. Pixels are looked up without
+// blending so the light matches the density it's sampled with exactly
+func (e *Environment) pixelAt(direction Vec3) (int, int) {
+	u, v := e.uv(direction)
+	x := min(int(u*float64(e.image.width)), e.image.width-1)
+	y := min(int(v*float64(e.image.height)), e.image.height-1)
+
+	return x, y
+}
+
+// Where a direction lands on the image, from 0 to 1 across and down
+func (e *Environment) uv(direction Vec3) (float64, float64) {
+	d := direction.Unit()
+
+	// Undo the rotation about +Y
+	sin, cos := math.Sincos(-e.rotation)
+	d = Vec3{cos*d.x + sin*d.z, d.y, -sin*d.x + cos*d.z}
+
+	u := (math.Atan2(-d.z, d.x) + math.Pi) / (2 * math.Pi)
+	v := math.Acos(Interval{-1, 1}.Clamp(d.y)) / math.Pi
+
+	return u - math.Floor(u), v
+}
+
+// Pick a pixel in proportion to its weight, then a direction within it
+func (e *Environment) SampleDirection(origin Vec3, rng *rand.Rand) (Vec3, float64, bool) {
+	if e.total <= 0 {
+		return Vec3{}, 0, false
+	}
+
+	// Binary search the row, then the pixel within it
+	y, _ := slices.BinarySearch(e.rowCDF, rng.Float64()*e.total)
+	y = min(y, e.image.height-1)
+
+	row := e.columnCDF[y*e.image.width : (y+1)*e.image.width]
+	x, _ := slices.BinarySearch(row, rng.Float64()*e.rowWeight[y])
+	x = min(x, e.image.width-1)
+
+	u := (float64(x) + rng.Float64()) / float64(e.image.width)
+	v := (float64(y) + rng.Float64()) / float64(e.image.height)
+	direction := equirectangularDirection(u, v)
+
+	// Turn the direction with the image
+	sin, cos := math.Sincos(e.rotation)
+	direction = Vec3{cos*direction.x + sin*direction.z, direction.y, -sin*direction.x + cos*direction.z}
+
+	pdf := e.DirectionPDF(origin, direction)
+	if pdf <= 0 {
+		return Vec3{}, 0, false
+	}
+
+	return direction, pdf, true
+}
+
+func (e *Environment) DirectionPDF(origin Vec3, direction Vec3) float64 {
+	if e.total <= 0 {
+		return 0
+	}
+
+	_, v := e.uv(direction)
+	sinTheta := math.Sin(v * math.Pi)
+	if sinTheta <= 0 {
+		return 0
+	}
+
+	// The density over the image, converted to a density over directions by
+	// the area of the sphere each part of the image covers
+	x, y := e.pixelAt(direction)
+	weight := math.Max(0, luminance(e.image.pixels[y*e.image.width+x])) * math.Sin((float64(y)+0.5)/float64(e.image.height)*math.Pi)
+	imagePDF := weight / e.total * float64(e.image.width*e.image.height)
+
+	return imagePDF / (2 * math.Pi * math.Pi * sinTheta)
+}
+
+// The environment is only seen by rays that miss everything
+func (e *Environment) Incoming(origin Vec3, direction Vec3) Vec3 {
+	if !escapes(origin, direction) {
+		return Vec3{0, 0, 0}
+	}
+
+	return e.Radiance(direction)
+}
+
+// The direction at a point on an equirectangular image, from 0 to 1 across
+// and down, before any rotation
+func equirectangularDirection(u float64, v float64) Vec3 {
+	phi := 2*math.Pi*u - math.Pi
+	theta := v * math.Pi
+	sinTheta := math.Sin(theta)
+
+	return Vec3{sinTheta * math.Cos(phi), math.Cos(theta), -sinTheta * math.Sin(phi)}
+}
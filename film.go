@@ -0,0 +1,76 @@
+package main
+
+import (
+	"image"
+	"math/rand/v2"
+)
+
+// Accumulates the samples taken for every pixel over any number of passes, so
+// the image can keep converging while the camera and scene stay the same
+type Film struct {
+	width   int
+	height  int
+	camera  Camera
+	sums    []Vec3 // The total of every sample taken for each pixel
+	samples int    // The number of samples taken for every pixel so far
+	passes  int
+}
+
+func NewFilm(width int, height int, camera Camera) *Film {
+	return &Film{
+		width:  width,
+		height: height,
+		camera: camera,
+		sums:   make([]Vec3, width*height),
+	}
+}
+
+func (f *Film) Samples() int {
+	return f.samples
+}
+
+// Render another pass of samples for every pixel and add them to the film
+func (f *Film) AddPass(samples int) {
+	// Every pass needs different random numbers, but the same seed must still
+	// produce the same sequence of passes
+	seed := renderSeed + uint64(f.passes)*0x9e3779b97f4a7c15
+
+	// A single sample is best taken from the middle of the pixel, but once
+	// there are more they should spread across it
+	jitter := samples > 1 || f.passes > 0
+
+	renderTiles(f.width, f.height, seed, func(t tile, rng *rand.Rand) {
+		for y := t.y0; y < t.y1; y++ {
+			for x := t.x0; x < t.x1; x++ {
+				i := y*f.width + x
+				f.sums[i] = f.sums[i].Add(samplePixel(x, y, f.camera, samples, jitter, rng))
+			}
+		}
+	})
+
+	f.samples += samples
+	f.passes++
+}
+
+// The average of the samples taken for a pixel, as linear RGB
+func (f *Film) Pixel(x int, y int) Vec3 {
+	if f.samples == 0 {
+		return Vec3{}
+	}
+
+	return f.sums[y*f.width+x].Div(float64(f.samples))
+}
+
+// Write the average of the accumulated samples to the pixel buffer
+// This is the only place colors are squeezed down to 8 bits per channel
+func (f *Film) Develop(pixelBuffer *image.RGBA) {
+	if f.samples == 0 {
+		return
+	}
+
+	for y := 0; y < f.height; y++ {
+		for x := 0; x < f.width; x++ {
+			pixelBuffer.SetRGBA(x, y, displayColor(f.Pixel(x, y)))
+		}
+	}
+}
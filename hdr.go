@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Load a high dynamic range Radiance .hdr (RGBE) or .pfm image, whose pixels
+// already hold linear RGB that can go beyond 1
+func loadHDRTexture(path string, wrap WrapMode) (*ImageTexture, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	magic, err := r.Peek(2)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var texture *ImageTexture
+	if string(magic) == "PF" || string(magic) == "Pf" {
+		texture, err = parsePFM(r)
+	} else {
+		texture, err = parseRGBE(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	texture.wrap = wrap
+	return texture, nil
+}
+
+// Parse a Radiance RGBE image, where each pixel is an 8 bit mantissa for each
+// channel sharing an exponent, with rows usually run length encoded
+func parseRGBE(r *bufio.Reader) (*ImageTexture, error) {
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "#?") {
+		return nil, errors.New("not a Radiance HDR file")
+	}
+
+	// The header is a list of settings ending with a blank line
+	for {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("reading header: %w", err)
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if format, ok := strings.CutPrefix(line, "FORMAT="); ok && format != "32-bit_rle_rgbe" {
+			return nil, fmt.Errorf("unsupported format %q", format)
+		}
+	}
+
+	// Only the usual orientation, with rows from the top down, is supported
+	line, err = r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("reading size: %w", err)
+	}
+
+	var width, height int
+	if _, err := fmt.Sscanf(line, "-Y %d +X %d", &height, &width); err != nil || width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("unsupported size line %q", strings.TrimSpace(line))
+	}
+
+	texture := &ImageTexture{width: width, height: height, pixels: make([]Vec3, 0, width*height)}
+	scanline := make([]byte, 4*width)
+
+	for y := range height {
+		if err := readRGBEScanline(r, scanline, width); err != nil {
+			return nil, fmt.Errorf("row %d: %w", y, err)
+		}
+
+		for x := range width {
+			rgbe := scanline[4*x : 4*x+4]
+			if rgbe[3] == 0 {
+				texture.pixels = append(texture.pixels, Vec3{})
+				continue
+			}
+
+			scale := math.Ldexp(1, int(rgbe[3])-(128+8))
+			texture.pixels = append(texture.pixels, Vec3{float64(rgbe[0]), float64(rgbe[1]), float64(rgbe[2])}.Scale(scale))
+		}
+	}
+
+	return texture, nil
+}
+
+// Read one row of RGBE pixels into scanline, which holds four bytes per pixel
+func readRGBEScanline(r *bufio.Reader, scanline []byte, width int) error {
+	if _, err := io.ReadFull(r, scanline[:4]); err != nil {
+		return err
+	}
+
+	// Rows that aren't run length encoded start straight in with a pixel
+	if width < 8 || width > 0x7fff || scanline[0] != 2 || scanline[1] != 2 || scanline[2]&0x80 != 0 {
+		_, err := io.ReadFull(r, scanline[4:])
+		return err
+	}
+
+	if int(scanline[2])<<8|int(scanline[3]) != width {
+		return errors.New("run length encoded row has the wrong width")
+	}
+
+	// Each channel is stored separately as runs of one repeated byte and runs
+	// of different bytes
+	for channel := range 4 {
+		for x := 0; x < width; {
+			count, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+
+			repeat := count > 128
+			if repeat {
+				count -= 128
+			}
+			if count == 0 || x+int(count) > width {
+				return errors.New("bad run length")
+			}
+
+			value, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+
+			for i := range int(count) {
+				if !repeat && i > 0 {
+					if value, err = r.ReadByte(); err != nil {
+						return err
+					}
+				}
+				scanline[4*x+channel] = value
+				x++
+			}
+		}
+	}
+
+	return nil
+}
+
+// Parse a Portable Float Map, a short text header followed by 32 bit floats
+// for each channel, with rows from the bottom up
+func parsePFM(r *bufio.Reader) (*ImageTexture, error) {
+	// The header is four whitespace separated fields, with a single
+	// whitespace character between the last one and the data
+	fields := make([]string, 0, 4)
+	field := []byte{}
+	for len(fields) < 4 {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("reading header: %w", err)
+		}
+
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			if len(field) > 0 {
+				fields = append(fields, string(field))
+				field = field[:0]
+			}
+		default:
+			field = append(field, c)
+		}
+	}
+
+	channels := 3
+	if fields[0] == "Pf" {
+		channels = 1
+	}
+
+	width, err := strconv.Atoi(fields[1])
+	if err != nil || width <= 0 {
+		return nil, fmt.Errorf("bad width %q", fields[1])
+	}
+	height, err := strconv.Atoi(fields[2])
+	if err != nil || height <= 0 {
+		return nil, fmt.Errorf("bad height %q", fields[2])
+	}
+	scale, err := strconv.ParseFloat(fields[3], 64)
+	if err != nil || scale == 0 {
+		return nil, fmt.Errorf("bad scale %q", fields[3])
+	}
+
+	// A negative scale means the floats are little endian
+	var order binary.ByteOrder = binary.BigEndian
+	if scale < 0 {
+		order = binary.LittleEndian
+	}
+
+	values := make([]float32, width*height*channels)
+	if err := binary.Read(r, order, values); err != nil {
+		return nil, fmt.Errorf("reading pixels: %w", err)
+	}
+
+	texture := &ImageTexture{width: width, height: height, pixels: make([]Vec3, width*height)}
+	for i := range width * height {
+		// Flip the rows so they go from the top down like other images
+		x, y := i%width, height-1-i/width
+		v := values[i*channels : (i+1)*channels]
+
+		pixel := Vec3{float64(v[0]), float64(v[0]), float64(v[0])}
+		if channels == 3 {
+			pixel = Vec3{float64(v[0]), float64(v[1]), float64(v[2])}
+		}
+		texture.pixels[y*width+x] = pixel
+	}
+
+	return texture, nil
+}
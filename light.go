@@ -0,0 +1,336 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+)
+
+// Something that gives off light and can be sampled directly, so that shading
+// points can send shadow rays straight at it instead of waiting for a random
+// bounce to find it
+type Light interface {
+	// Pick a direction from the point towards the light
+	// Returns the unit direction and its probability density with respect
+	// to solid angle, or false if the light can't be sampled from there
+	SampleDirection(origin Vec3, rng *rand.Rand) (Vec3, float64, bool)
+
+	// The probability density, with respect to solid angle, that
+	// SampleDirection picks the direction from the point
+	DirectionPDF(origin Vec3, direction Vec3) float64
+
+	// The light arriving at the point from the direction, if nothing else is
+	// in the way of the light
+	Incoming(origin Vec3, direction Vec3) Vec3
+}
+
+// Find every light in the objects, including the emissive triangles of meshes
+// and the lights inside transformed objects
+func collectLights(objects []Object) []Light {
+	lights := []Light{}
+
+	for _, o := range objects {
+		switch o := o.(type) {
+		case Sphere:
+			if o.material.Emitted() != (Vec3{}) {
+				lights = append(lights, o)
+			}
+		case Triangle:
+			if o.Material().Emitted() != (Vec3{}) {
+				lights = append(lights, o)
+			}
+		case Quad:
+			if o.material.Emitted() != (Vec3{}) {
+				lights = append(lights, o)
+			}
+		case Disk:
+			if o.material.Emitted() != (Vec3{}) {
+				lights = append(lights, o)
+			}
+		case Box:
+			lights = append(lights, collectLights(o.sides[:])...)
+		case *Mesh:
+			lights = append(lights, collectLights(o.triangles)...)
+		case Transformed:
+			for _, light := range collectLights([]Object{o.object}) {
+				lights = append(lights, NewTransformed(light.(Object), o.transform))
+			}
+		}
+	}
+
+	return lights
+}
+
+// How many of the objects glow somewhere that can't be sampled as a light, so
+// that they're only found by paths that happen to hit them and light the scene
+// more noisily. Only spheres, quads, disks, boxes and triangles are sampled
+func countUnsampledLights(objects []Object) int {
+	count := 0
+
+	for _, o := range objects {
+		switch o := o.(type) {
+		case Sphere, Triangle, Quad, Disk, Box, *Mesh:
+			// Every glowing part of these is sampled
+		case Transformed:
+			count += countUnsampledLights([]Object{o.object})
+		default:
+			if glows(o) {
+				count++
+			}
+		}
+	}
+
+	return count
+}
+
+// Whether any part of the object glows
+func glows(o Object) bool {
+	emits := func(m Material) bool {
+		return m != nil && m.Emitted() != (Vec3{})
+	}
+
+	switch o := o.(type) {
+	case Sphere:
+		return emits(o.material)
+	case Triangle:
+		return emits(o.Material())
+	case Quad:
+		return emits(o.material)
+	case Disk:
+		return emits(o.material)
+	case Plane:
+		return emits(o.material)
+	case Cylinder:
+		return emits(o.material)
+	case Capsule:
+		return emits(o.material)
+	case Torus:
+		return emits(o.material)
+	case *SDFObject:
+		return emits(o.material)
+	case Box:
+		return slices.ContainsFunc(o.sides[:], glows)
+	case *Mesh:
+		return slices.ContainsFunc(o.triangles, glows)
+	case Transformed:
+		return glows(o.object)
+	case CSG:
+		return glows(o.a) || glows(o.b)
+	}
+
+	return false
+}
+
+// The power heuristic for weighting two sampling strategies against each other
+// in multiple importance sampling
+func powerHeuristic(pdf float64, otherPdf float64) float64 {
+	if math.IsInf(pdf, 1) {
+		return 1
+	}
+
+	a, b := pdf*pdf, otherPdf*otherPdf
+	if a+b == 0 {
+		return 0
+	}
+
+	return a / (a + b)
+}
+
+// The probability density, with respect to solid angle, that sampleLight
+// picks a direction from the point that hits the light
+func lightPDF(light Light, origin Vec3, direction Vec3) float64 {
+	return light.DirectionPDF(origin, direction) / float64(len(lights))
+}
+
+// Estimate the light arriving directly from the scene's lights at a point on
+// a surface and leaving towards wo, by picking a light and sending a shadow
+// ray at it
+func sampleLight(hit HitRecord, wo Vec3, rng *rand.Rand) Vec3 {
+	if len(lights) == 0 {
+		return Vec3{0, 0, 0}
+	}
+
+	light := lights[rng.IntN(len(lights))]
+	direction, pdf, ok := light.SampleDirection(hit.point, rng)
+	if !ok || pdf <= 0 {
+		return Vec3{0, 0, 0}
+	}
+	pdf /= float64(len(lights))
+
+	f := hit.material.Eval(hit, wo, direction)
+	if f == (Vec3{}) || hit.Leaks(wo, direction) {
+		return Vec3{0, 0, 0}
+	}
+
+	incoming := light.Incoming(hit.point, direction)
+	if incoming == (Vec3{}) {
+		return Vec3{0, 0, 0}
+	}
+
+	weight := powerHeuristic(pdf, hit.material.PDF(hit, wo, direction))
+	return incoming.MulVec(f).Scale(weight / pdf)
+}
+
+// The light from an emissive object arriving at the point from the direction
+// The shadow ray has to reach the front of the object without anything in the
+// way
+func surfaceIncoming(light Object, origin Vec3, direction Vec3) Vec3 {
+	shadowRay := Ray{origin, direction}
+	lightHit, ok := world.Hit(shadowRay, Interval{0.0001, math.MaxFloat64})
+	if !ok || lightHit.object != light || !shadowRay.HitFront(lightHit.geometricNormal) {
+		return Vec3{0, 0, 0}
+	}
+
+	return lightHit.material.Emitted()
+}
+
+// Whether a ray from the point in the direction misses everything, reaching
+// the sky
+func escapes(origin Vec3, direction Vec3) bool {
+	_, ok := world.Hit(Ray{origin, direction}, Interval{0.0001, math.MaxFloat64})
+	return !ok
+}
+
+// Sample a direction towards the sphere inside the cone it fills as seen from
+// the point, which wastes no samples on directions that miss it
+func (s Sphere) SampleDirection(origin Vec3, rng *rand.Rand) (Vec3, float64, bool) {
+	toCenter := s.position.Sub(origin)
+	distanceSquared := toCenter.LengthSquared()
+	radiusSquared := s.radius * s.radius
+
+	// Points inside the sphere see it in every direction
+	if distanceSquared <= radiusSquared {
+		return Vec3{}, 0, false
+	}
+
+	cosThetaMax := math.Sqrt(1 - radiusSquared/distanceSquared)
+	cosTheta := 1 - rng.Float64()*(1-cosThetaMax)
+	sinTheta := math.Sqrt(math.Max(0, 1-cosTheta*cosTheta))
+	phi := 2 * math.Pi * rng.Float64()
+
+	axis := toCenter.Unit()
+	tangent, bitangent := axis.Basis()
+	direction := Vec3{math.Cos(phi) * sinTheta, math.Sin(phi) * sinTheta, cosTheta}.FromBasis(tangent, bitangent, axis)
+
+	return direction, 1 / (2 * math.Pi * (1 - cosThetaMax)), true
+}
+
+func (s Sphere) DirectionPDF(origin Vec3, direction Vec3) float64 {
+	toCenter := s.position.Sub(origin)
+	distanceSquared := toCenter.LengthSquared()
+	radiusSquared := s.radius * s.radius
+
+	if distanceSquared <= radiusSquared {
+		return 0
+	}
+
+	// Directions outside the cone can never be picked
+	cosThetaMax := math.Sqrt(1 - radiusSquared/distanceSquared)
+	if direction.Unit().Dot(toCenter.Unit()) < cosThetaMax {
+		return 0
+	}
+
+	return 1 / (2 * math.Pi * (1 - cosThetaMax))
+}
+
+func (s Sphere) Incoming(origin Vec3, direction Vec3) Vec3 {
+	return surfaceIncoming(s, origin, direction)
+}
+
+// Sample a point uniformly over the area of the triangle, and convert its
+// density to solid angle as seen from the origin
+func (t Triangle) SampleDirection(origin Vec3, rng *rand.Rand) (Vec3, float64, bool) {
+	v0, v1, v2 := t.Vertices()
+
+	// Fold the unit square onto the triangle to keep the points uniform
+	a, b := rng.Float64(), rng.Float64()
+	if a+b > 1 {
+		a, b = 1-a, 1-b
+	}
+	point := v0.Add(v1.Sub(v0).Scale(a)).Add(v2.Sub(v0).Scale(b))
+
+	normal := v1.Sub(v0).Cross(v2.Sub(v0))
+	return directionToPoint(origin, point, normal.Unit(), normal.Length()/2)
+}
+
+func (t Triangle) DirectionPDF(origin Vec3, direction Vec3) float64 {
+	v0, v1, v2 := t.Vertices()
+	normal := v1.Sub(v0).Cross(v2.Sub(v0))
+	return areaDirectionPDF(t, origin, direction, normal.Unit(), normal.Length()/2)
+}
+
+func (t Triangle) Incoming(origin Vec3, direction Vec3) Vec3 {
+	return surfaceIncoming(t, origin, direction)
+}
+
+// Sample a point uniformly over the area of the quad
+func (q Quad) SampleDirection(origin Vec3, rng *rand.Rand) (Vec3, float64, bool) {
+	point := q.corner.Add(q.edgeU.Scale(rng.Float64())).Add(q.edgeV.Scale(rng.Float64()))
+	return directionToPoint(origin, point, q.normal, q.Area())
+}
+
+func (q Quad) DirectionPDF(origin Vec3, direction Vec3) float64 {
+	return areaDirectionPDF(q, origin, direction, q.normal, q.Area())
+}
+
+func (q Quad) Incoming(origin Vec3, direction Vec3) Vec3 {
+	return surfaceIncoming(q, origin, direction)
+}
+
+// Sample a point uniformly over the area of the disk
+func (d Disk) SampleDirection(origin Vec3, rng *rand.Rand) (Vec3, float64, bool) {
+	// Points further out are picked more often, since there's more room there
+	r := d.radius * math.Sqrt(rng.Float64())
+	phi := 2 * math.Pi * rng.Float64()
+	point := d.center.Add(d.tangent.Scale(r * math.Cos(phi))).Add(d.bitangent.Scale(r * math.Sin(phi)))
+
+	return directionToPoint(origin, point, d.normal, math.Pi*d.radius*d.radius)
+}
+
+func (d Disk) DirectionPDF(origin Vec3, direction Vec3) float64 {
+	return areaDirectionPDF(d, origin, direction, d.normal, math.Pi*d.radius*d.radius)
+}
+
+func (d Disk) Incoming(origin Vec3, direction Vec3) Vec3 {
+	return surfaceIncoming(d, origin, direction)
+}
+
+// The direction from the origin to a point picked uniformly on a flat light,
+// with its density converted to solid angle
+func directionToPoint(origin Vec3, point Vec3, normal Vec3, area float64) (Vec3, float64, bool) {
+	toPoint := point.Sub(origin)
+	distanceSquared := toPoint.LengthSquared()
+	if distanceSquared == 0 {
+		return Vec3{}, 0, false
+	}
+	direction := toPoint.Unit()
+
+	pdf := solidAnglePDF(direction, distanceSquared, normal, area)
+	if pdf <= 0 {
+		return Vec3{}, 0, false
+	}
+
+	return direction, pdf, true
+}
+
+// The density with which directionToPoint picks the direction, found by
+// following it to the light
+func areaDirectionPDF(light Object, origin Vec3, direction Vec3, normal Vec3, area float64) float64 {
+	hit, ok := light.Hit(Ray{origin, direction}, Interval{0.0001, math.MaxFloat64})
+	if !ok {
+		return 0
+	}
+
+	return solidAnglePDF(direction.Unit(), hit.point.Sub(origin).LengthSquared(), normal, area)
+}
+
+// Convert the density of picking a point uniformly on a flat light to a
+// density over directions from a point that distance away
+func solidAnglePDF(direction Vec3, distanceSquared float64, normal Vec3, area float64) float64 {
+	cosine := math.Abs(direction.Dot(normal))
+	if cosine < 1e-8 || area == 0 {
+		return 0
+	}
+
+	return distanceSquared / (cosine * area)
+}
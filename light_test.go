@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestTransformedLightsAreHit(t *testing.T) {
+	keepSettings(t)
+
+	glowing := Lambertian{Emission: Emission{emission: Vec3{1, 1, 1}, emissionIntensity: 1}}
+	identity, _ := NewTransform(IdentityMatrix())
+	moved, _ := NewTransform(TranslationMatrix(Vec3{0, 0, -5}))
+
+	tests := []struct {
+		name   string
+		object Object
+	}{
+		{"identity sphere", NewTransformed(Sphere{Vec3{0, 0, -5}, 1, glowing}, identity)},
+		{"identity quad", NewTransformed(NewQuad(Vec3{-1, -1, -5}, Vec3{2, 0, 0}, Vec3{0, 2, 0}, glowing), identity)},
+		{"moved sphere", NewTransformed(Sphere{Vec3{0, 0, 0}, 1, glowing}, moved)},
+		{"nested sphere", NewTransformed(NewTransformed(Sphere{Vec3{0, 0, 0}, 1, glowing}, moved), identity)},
+		{"identity box", NewTransformed(NewBox(Vec3{-1, -1, -6}, Vec3{1, 1, -4}, glowing), identity)},
+	}
+
+	for _, test := range tests {
+		objects := []Object{test.object}
+		world = NewBVH(objects, false)
+
+		found := collectLights(objects)
+		if len(found) == 0 {
+			t.Errorf("%s: no lights found", test.name)
+			continue
+		}
+
+		// The light straight ahead has to be the one the shadow ray hits
+		lit := false
+		for _, light := range found {
+			if light.Incoming(Vec3{0, 0, 0}, Vec3{0, 0, -1}) != (Vec3{}) {
+				lit = true
+			}
+		}
+		if !lit {
+			t.Errorf("%s: shadow ray didn't reach the light", test.name)
+		}
+	}
+}
+
+func TestCountUnsampledLights(t *testing.T) {
+	glowing := Lambertian{Emission: Emission{emission: Vec3{1, 1, 1}, emissionIntensity: 1}}
+	matte := Lambertian{}
+	identity, _ := NewTransform(IdentityMatrix())
+	holed, _ := NewCSG(CSGDifference, Sphere{Vec3{0, 0, 0}, 1, glowing}, Sphere{Vec3{1, 0, 0}, 1, matte})
+
+	objects := []Object{
+		Sphere{Vec3{0, 0, 0}, 1, glowing},
+		NewQuad(Vec3{0, 0, 0}, Vec3{1, 0, 0}, Vec3{0, 1, 0}, glowing),
+		NewCylinder(Vec3{0, 0, 0}, Vec3{0, 1, 0}, 1, 1, matte),
+		NewCylinder(Vec3{0, 0, 0}, Vec3{0, 1, 0}, 1, 1, glowing),
+		NewTransformed(NewCapsule(Vec3{0, 0, 0}, Vec3{0, 1, 0}, 1, 1, glowing), identity),
+		holed,
+	}
+
+	if got := countUnsampledLights(objects); got != 3 {
+		t.Errorf("got %d unsampled lights, want 3", got)
+	}
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"image"
 	"image/color"
 	"log"
 	"math"
 	"math/rand/v2"
+	"slices"
+	"strings"
 	"time"
 
 	"golang.org/x/exp/shiny/driver"
@@ -23,7 +26,20 @@ var (
 	// screenWidth, screenHeight = 1280, 720 // Medium-high res
 	screenWidth, screenHeight = 640, 360 // Lower res for dev testing
 	// aspectRatio                       = screenWidth / screenHeight
-	viewportHeight float64 = 1
+
+	// Where the camera sits, what it looks at, which way is up and its
+	// vertical field of view in degrees
+	cameraLookFrom         = Vec3{0, 0, 0}
+	cameraLookAt           = Vec3{0, 0, -1}
+	cameraUp               = Vec3{0, 1, 0}
+	cameraVFOV     float64 = 53.13
+
+	// Depth of field settings: the angle of the cone of rays through each
+	// pixel in degrees, the distance that is in perfect focus (0 focuses on
+	// cameraLookAt) and the number of aperture blades (0 for a round aperture)
+	cameraDefocusAngle   float64 = 0
+	cameraFocusDist      float64 = 0
+	cameraApertureBlades int     = 0
 
 	// Scene selectors
 	drawMode = 2 // [noise, rainbowRectangle, rayTraced]
@@ -33,9 +49,28 @@ var (
 	// Min blue value for rainbow rectangle
 	minBlue float64 = 128
 
-	// RGB values for white and the sky
-	white = Vec3{x: float64(maxColorVal), y: float64(maxColorVal), z: float64(maxColorVal)}
-	sky   = Vec3{x: 127, y: 192, z: float64(maxColorVal)}
+	// Linear RGB values for white and the sky
+	white = linearFromRGBA(color.RGBA{maxColorVal, maxColorVal, maxColorVal, maxColorVal})
+	sky   = linearFromRGBA(color.RGBA{127, 192, maxColorVal, maxColorVal})
+
+	// What rays that miss everything see: the "gradient" between white and
+	// the sky, "black", the scene's "environment" map, or a "physical" sky
+	// and sun
+	skyType = "gradient"
+
+	// The image lighting the scene from all around, loaded from the scene file
+	environment *Environment
+
+	// The daylight for the physical sky, with the sun halfway up in front
+	// unless the scene file moves it
+	physicalSky = NewPhysicalSky(degreesToRadians(45), 0, 3, 1)
+
+	// How the rendered light is turned into colors on the screen: the tone
+	// mapping operator, the exposure in stops and, for extended Reinhard, the
+	// luminance that maps to white
+	toneMapName         = "clamp"
+	exposure    float64 = 0
+	whitePoint  float64 = 4
 
 	// The number of color samples taken per pixel
 	// samplesPerPixel = 128 // Higher value for quality
@@ -47,7 +82,36 @@ var (
 	// This slice will store all the obejects in out scene
 	objects = make([]Object, 0)
 
+	// The acceleration structure built over the objects, used to find hits
+	world *BVH
+
+	// The emissive objects and sky, which diffuse surfaces send shadow rays
+	// towards
+	lights []Light
+
+	// Sample lights directly rather than waiting for bounces to find them
+	useLightSampling = true
+
+	// Print BVH statistics after every render
+	printStats = false
+
+	// The number of goroutines rendering the frame, 0 uses one per CPU
+	renderWorkers = 0
+
+	// The window stops adding passes once this many samples per pixel have
+	// been taken, 0 keeps going forever
+	maxSamples = 0
+
+	// Seeds the random sampling, so the same seed always renders the same image
+	renderSeed uint64 = 0
+
 	sizeEvent size.Event
+
+	// The scene file loaded at startup
+	scenePath = "scenes/default.json"
+
+	// When set, render a single frame to this file instead of opening a window
+	outputPath string
 )
 
 // Generate a pseudo-random uint16
@@ -56,11 +120,18 @@ func randomUint16() uint16 {
 }
 
 // Generate a random vec3
-func randomVec3() Vec3 {
-	return Vec3{rand.Float64() - 0.5, rand.Float64() - 0.5, rand.Float64() - 0.5}
+func randomVec3(rng *rand.Rand) Vec3 {
+	return Vec3{rng.Float64() - 0.5, rng.Float64() - 0.5, rng.Float64() - 0.5}
 }
 
-func randomRangeVec3(min float64, max float64) Vec3 {
+// Generate a random unit vector around +Z, more likely the closer it is to +Z
+// in proportion to the cosine of the angle between them
+func randomCosineDirection(rng *rand.Rand) Vec3 {
+	x, y := randomInUnitDisk(rng)
+	return Vec3{x, y, math.Sqrt(math.Max(0, 1-x*x-y*y))}
+}
+
+func randomRangeVec3(rng *rand.Rand, min float64, max float64) Vec3 {
 	// Gracefully handle bounds error
 	if min >= max {
 		return Vec3{}
@@ -70,9 +141,9 @@ func randomRangeVec3(min float64, max float64) Vec3 {
 	offset := float64(max - min)
 
 	// Get some random values on the interval [0, offset]
-	x := rand.Float64() * offset
-	y := rand.Float64() * offset
-	z := rand.Float64() * offset
+	x := rng.Float64() * offset
+	y := rng.Float64() * offset
+	z := rng.Float64() * offset
 
 	// Increase the floor such that every value is on the interval [min, max]
 	return Vec3{x + min, y + min, z + min}
@@ -80,18 +151,39 @@ func randomRangeVec3(min float64, max float64) Vec3 {
 
 // Create a camera sized and scaled for the current window size
 func createCamera() Camera {
-	// Setup the camera viewport
+	// Build an orthonormal basis for the camera's orientation
+	// w points backwards from where the camera is looking, u to its right and
+	// v straight up from its point of view
+	w := cameraLookFrom.Sub(cameraLookAt).Unit()
+	u := cameraUp.Cross(w).Unit()
+	v := w.Cross(u)
+
+	// Fit the viewport to the field of view at the distance that is in
+	// focus, which is where the camera is looking unless told otherwise
+	focalLength := cameraFocusDist
+	if focalLength <= 0 {
+		focalLength = cameraLookFrom.Sub(cameraLookAt).Length()
+	}
+	viewportHeight := 2 * math.Tan(degreesToRadians(cameraVFOV)/2) * focalLength
 	viewportWidth := (float64(screenWidth) / float64(screenHeight)) * viewportHeight
 
 	// Get vec3s that traverse the viewport plane in the same direction as the
 	// screen coordinate system
-	viewportX := Vec3{x: viewportWidth, y: 0, z: 0}
-	viewportY := Vec3{x: 0, y: -viewportHeight, z: 0}
+	viewportX := u.Scale(viewportWidth)
+	viewportY := v.Scale(-viewportHeight)
 
 	// Create a camera for the scene
 	camera := Camera{
-		position:       Vec3{x: 0, y: 0, z: 0},
-		focalLength:    1,
+		position:       cameraLookFrom,
+		lookAt:         cameraLookAt,
+		up:             cameraUp,
+		vfov:           cameraVFOV,
+		u:              u,
+		v:              v,
+		w:              w,
+		focalLength:    focalLength,
+		defocusAngle:   cameraDefocusAngle,
+		apertureBlades: cameraApertureBlades,
 		viewportHeight: viewportHeight,
 		viewportWidth:  viewportWidth,
 		viewportX:      viewportX,
@@ -110,6 +202,12 @@ func createCamera() Camera {
 	// Set the proprt location of the top left pixel in the camera
 	camera.pixel00 = camera.TopLeft().Add(camera.pixelDeltaX.Add(camera.pixelDeltaY).Div(2))
 
+	// Size the lens so that the rays through each pixel form a cone with the
+	// defocus angle at its tip
+	defocusRadius := focalLength * math.Tan(degreesToRadians(cameraDefocusAngle/2))
+	camera.defocusDiskU = u.Scale(defocusRadius)
+	camera.defocusDiskV = v.Scale(defocusRadius)
+
 	return camera
 }
 
@@ -167,233 +265,167 @@ func drawRainbowRectangle(pixelBuffer *image.RGBA) {
 }
 
 // Return the color of the sky if the ray misses all objects
-func raySkyColor(ray Ray) color.RGBA {
+func raySkyColor(ray Ray) Vec3 {
+	switch skyType {
+	case "black":
+		// A black sky lets the scene be lit by its own lights alone
+		return Vec3{0, 0, 0}
+	case "environment":
+		return environment.Radiance(ray.direction)
+	case "physical":
+		return physicalSky.Radiance(ray.direction)
+	}
+
 	// Get the color of the skybox at the given ray
 	c := 0.5 * (ray.direction.Unit().y + 1.0)
-	rgb := white.Scale(1 - c).Add(sky.Scale(c))
-
-	return color.RGBA{
-		R: uint8(rgb.x),
-		G: uint8(rgb.y),
-		B: uint8(rgb.z),
-		A: maxColorVal,
-	}
+	return white.Scale(1 - c).Add(sky.Scale(c))
 }
 
-// Determine the color based on the normal vector of the object
-func normalColor(normal Vec3) color.RGBA {
-	// Make sure we have positive numbers, then scale the normal to get usable color values
-	scaledNormal := Vec3{normal.x + 1, normal.y + 1, normal.z + 1}.Scale(float64(maxColorVal))
-	return color.RGBA{
-		R: uint8(scaledNormal.x / 2),
-		G: uint8(scaledNormal.y / 2),
-		B: uint8(scaledNormal.z / 2),
-		A: maxColorVal,
-	}
-}
+// The kinds of sky there are to choose from
+var skyTypes = []string{"gradient", "black", "environment", "physical"}
 
-// Take a linear component and transform it into a gamma value
-func linearToGamma(linear float64) float64 {
-	if linear > 0 {
-		return math.Sqrt(linear)
+// The sky as a light that can be sampled directly, or nil if it can't be
+func skyLight() Light {
+	switch skyType {
+	case "environment":
+		return environment
+	case "physical":
+		return physicalSky
 	}
 
-	return 0
+	return nil
 }
 
-// Determine the color based on the color of the object and its surface rougness
-func rayObjectColor(object Object, ray Ray, t float64, maxDepth int) color.RGBA {
-	// Find the normal of the hit object
-	hitNormal := object.UnitNormal(ray, t)
-
-	// Get the object color as a simple vec3 of RGB
-	objColor := object.Color()
-	objRGB := Vec3{float64(objColor.R), float64(objColor.G), float64(objColor.B)}
-
-	var castColor color.RGBA // This will store the color of a cast ray
-
-	// Store the RGB values of the ray cast into the scene
-	refractedRayCastColor := Vec3{0, 0, 0}
-
-	// Check for transparency
-	if object.Transparency() > 0 {
-		// Did the ray hit the front of the object?
-		hitFront := ray.HitFront(hitNormal)
-
-		newRayDir := object.Refract(ray.direction, hitNormal, hitFront).Unit()
-
-		castColor = rayColor(Ray{ray.At(t), newRayDir}, maxDepth-1)
+// Determine the color based on the normal vector of the object
+func normalColor(normal Vec3) Vec3 {
+	// Make sure we have positive numbers, then scale the normal to get usable color values
+	return Vec3{normal.x + 1, normal.y + 1, normal.z + 1}.Scale(0.5)
+}
 
-		refractedRayCastColor = Vec3{
-			float64(castColor.R),
-			float64(castColor.G),
-			float64(castColor.B)}
+// What color should the pixel be at the ray?
+// Follows the path of the light backwards from the camera, bouncing off
+// whatever it hits until it escapes to the sky, is absorbed or runs out of
+// bounces. Colors are linear RGB, where 1 is the brightest a display can show
+// but brighter values are kept rather than clipped
+func rayColor(ray Ray, maxDepth int, rng *rand.Rand) Vec3 {
+	radiance := Vec3{0, 0, 0}
+
+	// How much of the light found further along the path reaches the camera
+	throughput := Vec3{1, 1, 1}
+
+	// The density with which the last bounce picked the ray's direction, when
+	// the lights were also sampled directly from there, or 0 if they weren't
+	bsdfPDF := 0.0
+
+	for depth := 0; depth < maxDepth; depth++ {
+		// Find the closest object the ray hits
+		hit, ok := world.Hit(ray, Interval{0.0001, math.MaxFloat64})
+		if !ok {
+			background := raySkyColor(ray)
+
+			// The sky may have been found by sampling it directly too
+			if light := skyLight(); light != nil && bsdfPDF > 0 {
+				background = background.Scale(powerHeuristic(bsdfPDF, lightPDF(light, ray.origin, ray.direction)))
+			}
 
-		// Scale the returned values appropriately for their color channels
-		refractedRayCastColor = Vec3{
-			refractedRayCastColor.x * float64(objRGB.x) / float64(maxColorVal),
-			refractedRayCastColor.y * float64(objRGB.y) / float64(maxColorVal),
-			refractedRayCastColor.z * float64(objRGB.z) / float64(maxColorVal),
+			radiance = radiance.Add(throughput.MulVec(background))
+			break
 		}
-	}
 
-	// Determine the average reflectivity of the object
-	var reflectivity float64 = objRGB.x / float64(maxColorVal)
-	reflectivity += objRGB.y / float64(maxColorVal)
-	reflectivity += objRGB.z / float64(maxColorVal)
-
-	reflectivity /= 3
-
-	// Check against transparency
-	reflectivity *= 1 - object.Transparency()
-
-	// Store the RGB values of the ray cast into the scene
-	reflectedRayCastColor := Vec3{0, 0, 0}
+		// // The unit normal vector where the ray hits the object
+		// return normalColor(hit.normal)
 
-	// Don't send off reflected rays unnecessarily
-	if reflectivity > 0 {
-		newRayDir := ray.direction.Reflect(hitNormal)
+		material := hit.material
+		hit = material.Perturb(hit)
 
-		// Don't calculate any random vectors unless there's a need to
-		if object.Roughness() > 0 {
-			// Generate a random unit vector
-			randomUnit := randomVec3().Unit()
+		// Lights only shine out of the front of their surface
+		if ray.HitFront(hit.geometricNormal) {
+			emitted := material.Emitted()
 
-			// Make sure the random unit vector is on the same hemisphere as the
-			// normal vector at the point where the initial ray hit the object
-			if !randomUnit.OnPlane(hitNormal) {
-				randomUnit = randomUnit.Scale(-1)
+			// The last bounce may have found this light by sampling it directly
+			// too, so weigh the two ways of finding it against each other
+			if light, ok := hit.object.(Light); ok && bsdfPDF > 0 && emitted != (Vec3{}) {
+				emitted = emitted.Scale(powerHeuristic(bsdfPDF, lightPDF(light, ray.origin, ray.direction)))
 			}
 
-			newRayDir = newRayDir.Add(randomUnit.Scale(object.Roughness()))
+			radiance = radiance.Add(throughput.MulVec(emitted))
 		}
 
-		newRayDir = newRayDir.Add(hitNormal.Scale(1 - object.Roughness()))
-
-		// Cast a ray and extract its color values
-		castColor = rayColor(Ray{ray.At(t), newRayDir}, maxDepth-1)
-		reflectedRayCastColor = Vec3{
-			float64(castColor.R),
-			float64(castColor.G),
-			float64(castColor.B)}
+		// Paths bent through the surface by its shading normal are absorbed
+		// rather than leaking light to the other side
+		scatter, ok := material.Scatter(ray, hit, rng)
+		if !ok || hit.Leaks(ray.direction.Scale(-1), scatter.direction) {
+			break
+		}
 
-		// Scale the returned values appropriately for their color channels
-		reflectedRayCastColor = Vec3{
-			reflectedRayCastColor.x * float64(objRGB.x) / float64(maxColorVal),
-			reflectedRayCastColor.y * float64(objRGB.y) / float64(maxColorVal),
-			reflectedRayCastColor.z * float64(objRGB.z) / float64(maxColorVal),
+		// Mirror-like bounces can't be lit by sampling the lights, since only
+		// one exact direction reflects any light at all
+		bsdfPDF = 0
+		if useLightSampling && !scatter.specular {
+			wo := ray.direction.Unit().Scale(-1)
+			radiance = radiance.Add(throughput.MulVec(sampleLight(hit, wo, rng)))
+			bsdfPDF = scatter.pdf
 		}
-	}
 
-	// Compose the color of the ray
-	scaledObjectRGB := objRGB.Scale(1 - reflectivity - object.Transparency())
-	scaledReflectedRayRGB := reflectedRayCastColor.Scale(reflectivity)
-	scaledRefractedRayRGB := refractedRayCastColor.Scale(object.Transparency())
+		throughput = throughput.MulVec(scatter.attenuation)
+		ray = Ray{hit.point, scatter.direction}
 
-	composedRGB := Vec3{
-		(scaledObjectRGB.x + scaledReflectedRayRGB.x + scaledRefractedRayRGB.x),
-		(scaledObjectRGB.y + scaledReflectedRayRGB.y + scaledRefractedRayRGB.y),
-		(scaledObjectRGB.z + scaledReflectedRayRGB.z + scaledRefractedRayRGB.z),
+		// Once the path has bounced a few times, randomly end the paths that
+		// carry little light, boosting the survivors to make up for it
+		if depth >= 3 {
+			survival := math.Min(0.95, math.Max(throughput.x, math.Max(throughput.y, throughput.z)))
+			if rng.Float64() >= survival {
+				break
+			}
+			throughput = throughput.Div(survival)
+		}
 	}
 
-	composedRGB = composedRGB.Scale(reflectivity + object.Transparency())
-
-	return color.RGBA{
-		uint8(composedRGB.x),
-		uint8(composedRGB.y),
-		uint8(composedRGB.z),
-		maxColorVal}
+	return radiance
 }
 
-// What color should the pixel be at the ray?
-func rayColor(ray Ray, maxDepth int) color.RGBA {
-	// If we have run out of depth, return blackness
-	if maxDepth < 1 {
-		return color.RGBA{0, 0, 0, 0}
-	}
-
-	// Track the closest object hit and create an interval for the hit range
-	hitInterval := Interval{0.0001, math.MaxFloat64}
-	var closestObj Object = nil
-
-	// Check if the ray hits any objects
-	for _, o := range objects {
-		// Get the distance to the object
-		t := o.Hit(ray, hitInterval)
-
-		// Check if there was a closer hit
-		if t > 0 {
-			hitInterval.max = t
-			closestObj = o
+// Cast several rays through the pixel and add up the colors they return
+// Without jitter every ray goes through the center of the pixel
+func samplePixel(x int, y int, camera Camera, samples int, jitter bool, rng *rand.Rand) Vec3 {
+	// Store the cumulative RGB values for the pixel color
+	pixelColor := Vec3{0, 0, 0}
+
+	// Take multiple samples for the pixel
+	for i := 0; i < samples; i++ {
+		// Generate some small random offsets for the pixel
+		// Potentially, generate one for each direction, but this ends
+		// up adding significant processing time
+		var offset float64 = 0
+		if jitter {
+			offset = rng.Float64() - 0.5
 		}
-	}
 
-	if closestObj != nil {
-		// return closestObj.Color() // Return the color of the object
+		// Scale the ratio differences by the coordinates of the current
+		// pixel, adding the offset to vary the direction
+		xVec := camera.pixelDeltaX.Scale(float64(x) + offset)
+		yVec := camera.pixelDeltaY.Scale(float64(y) + offset)
 
-		// // The unit normal vector where the ray hits the object
-		// return normalColor(closestObj.UnitNormal(ray, minT))
+		// Start somewhere on the lens, which is just the camera position when
+		// there is no defocus blur
+		origin := camera.RayOrigin(rng)
 
-		// Return the color of the object, accounting for roughness
-		return rayObjectColor(closestObj, ray, hitInterval.max, maxDepth)
+		// Calculate the offset from the ray origin to the pixel on the screen
+		directionToPixel := camera.pixel00.Add(xVec).Add(yVec).Sub(origin)
+
+		// Cast a ray from the lens to the pixel
+		r := Ray{origin: origin, direction: directionToPixel}
+		pixelColor = pixelColor.Add(rayColor(r, maxBounces, rng))
 	}
 
-	return raySkyColor(ray)
+	return pixelColor
 }
 
-// Write a raytraced frame to the pixel buffer
+// Write a raytraced frame to the pixel buffer, splitting the work across
+// goroutines
 func raytracedScene(pixelBuffer *image.RGBA, camera Camera) {
-	for y := 0; y < screenHeight; y++ {
-		for x := 0; x < screenWidth; x++ {
-			// Store the cumulative RGB values for the pixel color
-			pixelColor := Vec3{0, 0, 0}
-
-			// Take multiple samples for the pixel
-			for i := 0; i < samplesPerPixel; i++ {
-				// Generate some small random offsets for the pixel
-				// Potentially, generate one for each direction, but this ends
-				// up adding significant processing time
-				var offset float64 = 0
-				if samplesPerPixel > 1 {
-					offset = rand.Float64() - 0.5
-				}
-
-				// Scale the ratio differences by the coordinates of the current
-				// pixel, adding the offset to vary the direction
-				xVec := camera.pixelDeltaX.Scale(float64(x) + offset)
-				yVec := camera.pixelDeltaY.Scale(float64(y) + offset)
-
-				// Calculate the offset from the camera position to the pixel on the screen
-				directionToPixel := camera.pixel00.Add(xVec).Add(yVec).Sub(camera.position)
-
-				// Cast a ray from the camera center to the pixel
-				r := Ray{origin: camera.position, direction: directionToPixel}
-				colorOfRay := rayColor(r, maxBounces)
-
-				pixelColor.x += float64(colorOfRay.R)
-				pixelColor.y += float64(colorOfRay.G)
-				pixelColor.z += float64(colorOfRay.B)
-			}
-
-			// Average the pixel colors
-			pixelColor.x = pixelColor.x / float64(samplesPerPixel)
-			pixelColor.y = pixelColor.y / float64(samplesPerPixel)
-			pixelColor.z = pixelColor.z / float64(samplesPerPixel)
-
-			// // Gamma correction
-			// intensity := Interval{0, 1}
-			// pixelColor.x = intensity.Clamp(linearToGamma(pixelColor.x/float64(maxColorVal))) * float64(maxColorVal)
-			// pixelColor.y = intensity.Clamp(linearToGamma(pixelColor.y/float64(maxColorVal))) * float64(maxColorVal)
-			// pixelColor.z = intensity.Clamp(linearToGamma(pixelColor.z/float64(maxColorVal))) * float64(maxColorVal)
-
-			// Set the final pixel color
-			pixelBuffer.SetRGBA(x, y, color.RGBA{
-				uint8(pixelColor.x),
-				uint8(pixelColor.y),
-				uint8(pixelColor.z),
-				maxColorVal})
-		}
-	}
+	film := NewFilm(screenWidth, screenHeight, camera)
+	film.AddPass(samplesPerPixel)
+	film.Develop(pixelBuffer)
 }
 
 // The main render loop of the application
@@ -409,6 +441,9 @@ func render(s screen.Screen, window screen.Window, screenBuffer screen.Buffer) {
 	// We need a camera for the scene
 	camera := createCamera()
 
+	// Samples build up on the film until the camera or the screen changes
+	film := NewFilm(screenWidth, screenHeight, camera)
+
 	// Loop indefinitely, closing when the window is closed
 	for {
 		// Get the type of the next event on the window
@@ -419,6 +454,7 @@ func render(s screen.Screen, window screen.Window, screenBuffer screen.Buffer) {
 			handleResize(s, event, &screenBuffer)
 			camera = createCamera()
 			pixelBuffer = screenBuffer.RGBA()
+			film = NewFilm(screenWidth, screenHeight, camera)
 
 		// If the type of the event is lifecycle.Event
 		case lifecycle.Event:
@@ -436,21 +472,117 @@ func render(s screen.Screen, window screen.Window, screenBuffer screen.Buffer) {
 			case 1:
 				drawRainbowRectangle(pixelBuffer)
 			case 2:
-				raytracedScene(pixelBuffer, camera)
+				film.AddPass(samplesPerPixel)
+				film.Develop(pixelBuffer)
 			}
 
 			// Upload the updated pixel buffer to the screen
 			window.Upload(image.Point{0, 0}, screenBuffer, sizeEvent.Bounds())
 			window.Publish() // Draw the updated buffer to the screen
 			fmt.Printf("Render took %dms\n", time.Since(start).Milliseconds())
+
+			// Show how far the image has converged on the console, since the
+			// window has no way to show it
+			if drawMode == 2 {
+				fmt.Printf("%d samples per pixel\n", film.Samples())
+			}
+
+			if printStats {
+				fmt.Println(world.Stats())
+				world.ResetStats()
+			}
+
+			// Keep refining the image until it has enough samples
+			if drawMode == 2 && (maxSamples == 0 || film.Samples() < maxSamples) {
+				window.Send(paint.Event{})
+			}
 		}
 	}
 }
 
+// Read the render settings from the command line
+func parseFlags() {
+	flag.StringVar(&scenePath, "scene", scenePath, "JSON scene file to render")
+	flag.StringVar(&outputPath, "out", outputPath, "render one frame to this PNG or JPEG file instead of opening a window")
+	flag.IntVar(&screenWidth, "width", screenWidth, "width of the rendered image in pixels")
+	flag.IntVar(&screenHeight, "height", screenHeight, "height of the rendered image in pixels")
+	flag.IntVar(&samplesPerPixel, "samples", samplesPerPixel, "number of color samples taken per pixel")
+	flag.IntVar(&maxBounces, "bounces", maxBounces, "number of times a ray can bounce")
+	flag.BoolVar(&printStats, "stats", printStats, "print acceleration structure statistics after every render")
+	flag.IntVar(&maxSamples, "maxsamples", maxSamples, "stop refining the window image after this many samples per pixel, 0 for no limit")
+	flag.IntVar(&renderWorkers, "workers", renderWorkers, "number of goroutines to render with, 0 for one per CPU")
+	flag.Uint64Var(&renderSeed, "seed", renderSeed, "seed for the random sampling")
+	flag.StringVar(&skyType, "sky", skyType, "what rays that miss everything see: "+strings.Join(skyTypes, ", "))
+	flag.BoolVar(&useLightSampling, "nee", useLightSampling, "sample lights directly with shadow rays (next-event estimation)")
+	flag.StringVar(&toneMapName, "tonemap", toneMapName, "tone mapping operator: "+toneMapNames())
+	flag.Float64Var(&exposure, "exposure", exposure, "exposure adjustment in stops")
+	flag.Float64Var(&whitePoint, "whitepoint", whitePoint, "luminance that becomes white with the extended-reinhard tone map")
+	flag.IntVar(&jpegQuality, "quality", jpegQuality, "JPEG quality, from 1 to 100")
+	flag.Parse()
+}
+
+// Make sure the settings from the flags and scene file are usable
+func checkSettings() {
+	if screenWidth < 1 || screenHeight < 1 {
+		log.Fatalf("image size must be positive, got %dx%d", screenWidth, screenHeight)
+	}
+	if maxSamples < 0 {
+		log.Fatalf("max samples must not be negative, got %d", maxSamples)
+	}
+	if !slices.Contains(skyTypes, skyType) {
+		log.Fatalf("unknown sky %q (choose from %s)", skyType, strings.Join(skyTypes, ", "))
+	}
+	if skyType == "environment" && environment == nil {
+		log.Fatal("the environment sky needs a scene with an environment map")
+	}
+	if err := checkToneMap(toneMapName); err != nil {
+		log.Fatal(err)
+	}
+	if whitePoint <= 0 {
+		log.Fatalf("white point must be positive, got %v", whitePoint)
+	}
+	if renderWorkers < 0 {
+		log.Fatalf("worker count must not be negative, got %d", renderWorkers)
+	}
+	if samplesPerPixel < 1 {
+		log.Fatalf("samples per pixel must be positive, got %d", samplesPerPixel)
+	}
+}
+
 func main() {
 	// fmt.Printf("Hello World!" + " Look at me!")
 	defer func() { fmt.Println("All Done!") }() // Good cleanup!
 
+	parseFlags()
+
+	// Settings given on the command line take priority over the scene file
+	explicitFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicitFlags[f.Name] = true })
+
+	scene, err := loadScene(scenePath)
+	if err != nil {
+		log.Fatalf("couldn't load scene - %v", err)
+	}
+	applyScene(scene, explicitFlags)
+	checkSettings()
+
+	world = NewBVH(objects, printStats)
+	lights = collectLights(objects)
+	if light := skyLight(); light != nil {
+		lights = append(lights, light)
+	}
+	if count := countUnsampledLights(objects); count > 0 && useLightSampling {
+		log.Printf("%d glowing objects can't be sampled as lights, so they'll light the scene more noisily than spheres, quads, disks, boxes and triangles", count)
+	}
+
+	// Render without a window if an output file was requested
+	if outputPath != "" {
+		if err := renderHeadless(outputPath); err != nil {
+			log.Fatalf("couldn't render to %s - %v", outputPath, err)
+		}
+		return
+	}
+
 	// Run the provided anonymous function on the screen
 	driver.Main(func(s screen.Screen) {
 		// Create a new window with the screen
@@ -477,100 +609,6 @@ func main() {
 		}
 		defer screenBuffer.Release()
 
-		// Create some materials
-		groundMaterial := Material{
-			color:           color.RGBA{128, 128, 128, maxColorVal},
-			roughness:       1,
-			transparency:    0,
-			refractionIndex: 0,
-		}
-
-		defaultSphereMaterial := Material{
-			color:           color.RGBA{128, 128, 128, maxColorVal},
-			roughness:       1,
-			transparency:    0,
-			refractionIndex: 0,
-		}
-
-		metalMaterial := Material{
-			color:           color.RGBA{maxColorVal - 0xf, maxColorVal - 0xf, maxColorVal - 0xf, maxColorVal},
-			roughness:       0,
-			transparency:    0,
-			refractionIndex: 0,
-		}
-
-		yellowMetalMaterial := Material{
-			color:           color.RGBA{maxColorVal, maxColorVal, 128, maxColorVal},
-			roughness:       0.1,
-			transparency:    0,
-			refractionIndex: 0,
-		}
-
-		darkMetalMaterial := Material{
-			color:           color.RGBA{96, 96, 128, maxColorVal},
-			roughness:       0,
-			transparency:    0,
-			refractionIndex: 0,
-		}
-
-		diffuseWhiteMaterial := Material{
-			color:           color.RGBA{maxColorVal, maxColorVal, maxColorVal, maxColorVal},
-			roughness:       1,
-			transparency:    0,
-			refractionIndex: 0,
-		}
-
-		glassMaterial := Material{
-			color:           color.RGBA{maxColorVal, maxColorVal, maxColorVal, maxColorVal},
-			roughness:       0,
-			transparency:    1,
-			refractionIndex: 1.5,
-		}
-
-		// Add a ground sphere
-		objects = append(objects, Sphere{
-			position: Vec3{0, -100.5, -1},
-			radius:   100,
-			material: groundMaterial,
-		})
-
-		// Fill the scene with objects
-		objects = append(objects, Sphere{
-			position: Vec3{0, 0, -2},
-			radius:   0.5,
-			material: glassMaterial,
-		})
-
-		objects = append(objects, Sphere{
-			position: Vec3{-2, 0.5, -3.5},
-			radius:   1,
-			material: metalMaterial,
-		})
-
-		objects = append(objects, Sphere{
-			position: Vec3{1.5, 0, -2.5},
-			radius:   0.5,
-			material: yellowMetalMaterial,
-		})
-
-		objects = append(objects, Sphere{
-			position: Vec3{1.5, 3.5, -4},
-			radius:   3,
-			material: darkMetalMaterial,
-		})
-
-		objects = append(objects, Sphere{
-			position: Vec3{0, -0.4, -1.45},
-			radius:   0.1,
-			material: diffuseWhiteMaterial,
-		})
-
-		objects = append(objects, Sphere{
-			position: Vec3{0, 0.25, -5},
-			radius:   0.7,
-			material: defaultSphereMaterial,
-		})
-
 		render(s, window, screenBuffer)
 	})
 }
@@ -1,10 +1,507 @@
 package main
 
-import "image/color"
+import (
+	"math"
+	"math/rand/v2"
+)
 
-type Material struct {
-	color           color.RGBA
-	roughness       float64
-	transparency    float64
+// How a surface scatters and gives off light
+// Directions are unit vectors pointing away from the surface: wo towards
+// where the light is going (the viewer) and wi towards where it came from
+type Material interface {
+	// Pick a direction for the path to carry on in after hitting the surface
+	// Returns false if the light is absorbed
+	Scatter(ray Ray, hit HitRecord, rng *rand.Rand) (ScatterRecord, bool)
+
+	// The BSDF times the cosine of the angle between wi and the normal, which
+	// is the fraction of the light arriving from wi that leaves towards wo
+	Eval(hit HitRecord, wo Vec3, wi Vec3) Vec3
+
+	// The probability density, with respect to solid angle, that Scatter
+	// picks wi
+	PDF(hit HitRecord, wo Vec3, wi Vec3) float64
+
+	// The light given off by the front of the surface as linear RGB
+	Emitted() Vec3
+
+	// Bend the shading normal of the hit with the material's fine detail
+	Perturb(hit HitRecord) HitRecord
+}
+
+// The direction a material scattered a path in
+type ScatterRecord struct {
+	direction   Vec3
+	attenuation Vec3    // What the light from the new direction gets multiplied by, which is Eval / PDF
+	pdf         float64 // The density of picking the direction, 0 when it's specular
+	specular    bool    // The direction came from a mirror-like lobe that Eval and PDF can't describe
+}
+
+// The light a material gives off, embedded in every kind of material
+type Emission struct {
+	emission          Vec3    // The color of the light as linear RGB
+	emissionIntensity float64 // How bright the light is, 0 for none
+}
+
+func (e Emission) Emitted() Vec3 {
+	if e.emissionIntensity <= 0 {
+		return Vec3{}
+	}
+
+	return e.emission.Scale(e.emissionIntensity)
+}
+
+// Fine detail for a surface, embedded in every kind of material. A normal map
+// holds tangent space normals as linear RGB, and a bump map holds heights
+// from 0 to 1, scaled by the bump height in world units
+type Detail struct {
+	normalMap  Texture
+	bump       Texture
+	bumpHeight float64
+}
+
+func (d Detail) Perturb(hit HitRecord) HitRecord {
+	var normal Vec3
+	switch {
+	case d.normalMap != nil:
+		tangent, bitangent := hit.TangentFrame(hit.normal)
+		local := d.normalMap.Value(hit.u, hit.v, hit.point).Scale(2).Sub(Vec3{1, 1, 1})
+		normal = local.FromBasis(tangent, bitangent, hit.normal)
+
+	case d.bump != nil:
+		// Find how fast the surface rises along u and v, and tilt the
+		// directions it runs in by that much
+		const delta = 1e-3
+		height := scalarValue(d.bump, hit)
+		duHit, dvHit := hit, hit
+		duHit.u += delta
+		duHit.point = hit.point.Add(hit.dpdu.Scale(delta))
+		dvHit.v += delta
+		dvHit.point = hit.point.Add(hit.dpdv.Scale(delta))
+
+		dhdu := (scalarValue(d.bump, duHit) - height) / delta * d.bumpHeight
+		dhdv := (scalarValue(d.bump, dvHit) - height) / delta * d.bumpHeight
+
+		dpdu := hit.dpdu.Add(hit.normal.Scale(dhdu))
+		dpdv := hit.dpdv.Add(hit.normal.Scale(dhdv))
+		normal = dpdu.Cross(dpdv)
+
+		// The cross product points whichever way the texture coordinates
+		// turn, which may be into the surface
+		if normal.Dot(hit.normal) < 0 {
+			normal = normal.Scale(-1)
+		}
+
+	default:
+		return hit
+	}
+
+	if normal.LengthSquared() > 0 {
+		hit.normal = normal.Unit()
+	}
+
+	return hit
+}
+
+// A tangent and bitangent that form an orthonormal basis with the unit normal,
+// following the direction u increases in across the surface where there is one
+// The bitangent is flipped to follow v where the texture is mirrored
+func (h HitRecord) TangentFrame(normal Vec3) (Vec3, Vec3) {
+	tangent := h.dpdu.Sub(normal.Scale(normal.Dot(h.dpdu)))
+	if tangent.LengthSquared() < 1e-18 {
+		return normal.Basis()
+	}
+	tangent = tangent.Unit()
+
+	bitangent := normal.Cross(tangent)
+	if bitangent.Dot(h.dpdv) < 0 {
+		bitangent = bitangent.Scale(-1)
+	}
+
+	return tangent, bitangent
+}
+
+// Whether light going between wo and wi would cross the surface one way by its
+// shading normal but the other way by its real one, which lets light leak
+// through surfaces whose shading normals are bent too far
+func (h HitRecord) Leaks(wo Vec3, wi Vec3) bool {
+	shading := wo.Dot(h.normal) * wi.Dot(h.normal)
+	geometric := wo.Dot(h.geometricNormal) * wi.Dot(h.geometricNormal)
+
+	return (shading > 0) != (geometric > 0)
+}
+
+// The normal on the same side of the surface as the direction
+func (h HitRecord) FacingNormal(direction Vec3) Vec3 {
+	if direction.Dot(h.normal) < 0 {
+		return h.normal.Scale(-1)
+	}
+
+	return h.normal
+}
+
+// Implements Material interface
+// A perfectly matte surface that scatters light equally in every direction
+type Lambertian struct {
+	Emission
+	Detail
+	albedo Texture
+}
+
+func (l Lambertian) Scatter(ray Ray, hit HitRecord, rng *rand.Rand) (ScatterRecord, bool) {
+	normal := hit.FacingNormal(ray.direction.Scale(-1))
+
+	// Bounce in a cosine-weighted direction, which cancels out the cosine and
+	// the 1/pi in the BRDF to leave just the albedo
+	tangent, bitangent := normal.Basis()
+	direction := randomCosineDirection(rng).FromBasis(tangent, bitangent, normal)
+
+	return ScatterRecord{
+		direction:   direction,
+		attenuation: l.albedo.Value(hit.u, hit.v, hit.point),
+		pdf:         math.Max(0, direction.Dot(normal)) / math.Pi,
+	}, true
+}
+
+func (l Lambertian) Eval(hit HitRecord, wo Vec3, wi Vec3) Vec3 {
+	cosine := wi.Dot(hit.FacingNormal(wo))
+	if cosine <= 0 {
+		return Vec3{}
+	}
+
+	return l.albedo.Value(hit.u, hit.v, hit.point).Scale(cosine / math.Pi)
+}
+
+func (l Lambertian) PDF(hit HitRecord, wo Vec3, wi Vec3) float64 {
+	return math.Max(0, wi.Dot(hit.FacingNormal(wo))) / math.Pi
+}
+
+// Implements Material interface
+// A reflective surface. Rough metals scatter light around the mirror direction
+// following the GGX microfacet model. Metals made of a conductor get their
+// color from its complex index of refraction, and the rest reflect their
+// albedo when seen straight on
+type Metal struct {
+	Emission
+	Detail
+	albedo     Texture
+	conductor  *Conductor
+	roughness  Texture
+	anisotropy float64
+}
+
+func (m Metal) Scatter(ray Ray, hit HitRecord, rng *rand.Rand) (ScatterRecord, bool) {
+	wo := ray.direction.Unit().Scale(-1)
+	normal := hit.FacingNormal(wo)
+	distribution := m.distribution(hit)
+
+	if distribution.Smooth() {
+		direction := ray.direction.Unit().Reflect(normal)
+		return ScatterRecord{direction: direction, attenuation: m.fresnel(hit, wo.Dot(normal)), specular: true}, true
+	}
+
+	// Reflect off a microfacet picked from the ones that can be seen
+	tangent, bitangent := hit.TangentFrame(normal)
+	localWo := wo.ToBasis(tangent, bitangent, normal)
+	microfacet := distribution.SampleVisibleNormal(localWo, rng)
+	localWi := localWo.Scale(-1).Reflect(microfacet)
+
+	// Light reflected into the surface is blocked by the other microfacets
+	if localWi.z <= 0 {
+		return ScatterRecord{}, false
+	}
+
+	direction := localWi.FromBasis(tangent, bitangent, normal)
+	shadowing := distribution.G(localWo, localWi) / distribution.G1(localWo)
+
+	return ScatterRecord{
+		direction:   direction,
+		attenuation: m.fresnel(hit, localWo.Dot(microfacet)).Scale(shadowing),
+		pdf:         m.PDF(hit, wo, direction),
+	}, true
+}
+
+func (m Metal) Eval(hit HitRecord, wo Vec3, wi Vec3) Vec3 {
+	distribution := m.distribution(hit)
+	if distribution.Smooth() {
+		return Vec3{}
+	}
+
+	normal := hit.FacingNormal(wo)
+	tangent, bitangent := hit.TangentFrame(normal)
+	localWo := wo.ToBasis(tangent, bitangent, normal)
+	localWi := wi.ToBasis(tangent, bitangent, normal)
+	if localWo.z <= 0 || localWi.z <= 0 {
+		return Vec3{}
+	}
+
+	microfacet := localWo.Add(localWi).Unit()
+	d := distribution.D(microfacet)
+	g := distribution.G(localWo, localWi)
+
+	return m.fresnel(hit, localWo.Dot(microfacet)).Scale(d * g / (4 * localWo.z))
+}
+
+func (m Metal) PDF(hit HitRecord, wo Vec3, wi Vec3) float64 {
+	distribution := m.distribution(hit)
+	if distribution.Smooth() {
+		return 0
+	}
+
+	normal := hit.FacingNormal(wo)
+	tangent, bitangent := hit.TangentFrame(normal)
+	localWo := wo.ToBasis(tangent, bitangent, normal)
+	localWi := wi.ToBasis(tangent, bitangent, normal)
+	if localWi.z <= 0 {
+		return 0
+	}
+
+	microfacet := localWo.Add(localWi).Unit()
+	return distribution.VisibleNormalPDF(localWo, microfacet) / (4 * localWo.Dot(microfacet))
+}
+
+// The fraction of light the metal reflects at an angle with the given cosine
+// to the normal
+func (m Metal) fresnel(hit HitRecord, cosTheta float64) Vec3 {
+	if m.conductor != nil {
+		return m.conductor.Fresnel(cosTheta)
+	}
+
+	return schlickColor(cosTheta, m.albedo.Value(hit.u, hit.v, hit.point))
+}
+
+// The spread of the microfacets where the surface was hit
+func (m Metal) distribution(hit HitRecord) GGX {
+	return NewGGX(scalarValue(m.roughness, hit), m.anisotropy)
+}
+
+// Implements Material interface
+// A clear material such as glass or water that light passes through, tinted
+// by its color. Rough dielectrics, like frosted glass, scatter the light they
+// reflect and refract following the GGX microfacet model. Thin dielectrics are
+// a single pane, like a window or a soap bubble, that light passes straight
+// through without bending
+type Dielectric struct {
+	Emission
+	Detail
+	tint            Texture
 	refractionIndex float64
+	thin            bool
+	roughness       Texture
+	anisotropy      float64
+}
+
+func (d Dielectric) Scatter(ray Ray, hit HitRecord, rng *rand.Rand) (ScatterRecord, bool) {
+	direction := ray.direction.Unit()
+	if !d.thin && !d.distribution(hit).Smooth() {
+		return d.scatterRough(direction.Scale(-1), hit, rng)
+	}
+
+	normal, refractionRatio := d.orientation(hit, direction.Scale(-1))
+
+	cosTheta := math.Min(-direction.Dot(normal), 1)
+	reflectance := dielectricReflectance(cosTheta, refractionRatio)
+
+	// Light bounces back and forth inside a thin pane, and all of the light
+	// it reflects comes out of one side or the other
+	if d.thin {
+		reflectance = 2 * reflectance / (1 + reflectance)
+	}
+
+	// Pick reflection or refraction in proportion to how much light goes each
+	// way, so there's no need to weigh the path by the reflectance
+	refracted, ok := d.Refract(direction, normal, refractionRatio)
+	if !ok || rng.Float64() < reflectance {
+		return ScatterRecord{direction: direction.Reflect(normal), attenuation: Vec3{1, 1, 1}, specular: true}, true
+	}
+
+	if d.thin {
+		refracted = direction
+	}
+
+	return ScatterRecord{direction: refracted, attenuation: d.tint.Value(hit.u, hit.v, hit.point), specular: true}, true
+}
+
+// Reflect or refract through a microfacet picked from the ones that can be
+// seen from wo
+func (d Dielectric) scatterRough(wo Vec3, hit HitRecord, rng *rand.Rand) (ScatterRecord, bool) {
+	normal, refractionRatio := d.orientation(hit, wo)
+	distribution := d.distribution(hit)
+	tangent, bitangent := hit.TangentFrame(normal)
+	localWo := wo.ToBasis(tangent, bitangent, normal)
+	microfacet := distribution.SampleVisibleNormal(localWo, rng)
+	reflectance := dielectricReflectance(localWo.Dot(microfacet), refractionRatio)
+
+	var localWi Vec3
+	var attenuation Vec3
+	if rng.Float64() < reflectance {
+		localWi = localWo.Scale(-1).Reflect(microfacet)
+		attenuation = Vec3{1, 1, 1}
+		if localWi.z <= 0 {
+			return ScatterRecord{}, false
+		}
+	} else {
+		var ok bool
+		localWi, ok = d.Refract(localWo.Scale(-1), microfacet, refractionRatio)
+		attenuation = d.tint.Value(hit.u, hit.v, hit.point)
+		if !ok || localWi.z >= 0 {
+			return ScatterRecord{}, false
+		}
+	}
+
+	direction := localWi.FromBasis(tangent, bitangent, normal)
+	shadowing := distribution.G(localWo, localWi) / distribution.G1(localWo)
+
+	return ScatterRecord{
+		direction:   direction,
+		attenuation: attenuation.Scale(shadowing),
+		pdf:         d.PDF(hit, wo, direction),
+	}, true
+}
+
+func (d Dielectric) Eval(hit HitRecord, wo Vec3, wi Vec3) Vec3 {
+	localWo, localWi, microfacet, refractionRatio, ok := d.microfacet(hit, wo, wi)
+	if !ok {
+		return Vec3{}
+	}
+
+	cosO := localWo.Dot(microfacet)
+	cosI := localWi.Dot(microfacet)
+	reflectance := dielectricReflectance(cosO, refractionRatio)
+	distribution := d.distribution(hit)
+	dg := distribution.D(microfacet) * distribution.G(localWo, localWi)
+
+	if localWi.z > 0 {
+		return Vec3{1, 1, 1}.Scale(reflectance * dg / (4 * localWo.z))
+	}
+
+	denominator := refractionRatio*cosO + cosI
+	return d.tint.Value(hit.u, hit.v, hit.point).Scale(-cosI * cosO * (1 - reflectance) * dg / (localWo.z * denominator * denominator))
+}
+
+func (d Dielectric) PDF(hit HitRecord, wo Vec3, wi Vec3) float64 {
+	localWo, localWi, microfacet, refractionRatio, ok := d.microfacet(hit, wo, wi)
+	if !ok {
+		return 0
+	}
+
+	cosO := localWo.Dot(microfacet)
+	cosI := localWi.Dot(microfacet)
+	reflectance := dielectricReflectance(cosO, refractionRatio)
+	pdf := d.distribution(hit).VisibleNormalPDF(localWo, microfacet)
+
+	if localWi.z > 0 {
+		return pdf * reflectance / (4 * cosO)
+	}
+
+	denominator := refractionRatio*cosO + cosI
+	return pdf * (1 - reflectance) * -cosI / (denominator * denominator)
+}
+
+// The spread of the microfacets where the surface was hit
+func (d Dielectric) distribution(hit HitRecord) GGX {
+	return NewGGX(scalarValue(d.roughness, hit), d.anisotropy)
+}
+
+// The normal on the same side of the surface as wo, and the index of
+// refraction on that side over the one on the other side
+func (d Dielectric) orientation(hit HitRecord, wo Vec3) (Vec3, float64) {
+	// Light going into the material slows down, and speeds up coming back out
+	refractionRatio := d.refractionIndex
+	if wo.Dot(hit.geometricNormal) > 0 || d.thin {
+		refractionRatio = 1 / d.refractionIndex
+	}
+
+	return hit.FacingNormal(wo), refractionRatio
+}
+
+// Find the microfacet normal that reflects or refracts wo into wi on a rough
+// dielectric, with both directions given in the microfacet's local space.
+// Returns false if the two can't be joined by any microfacet
+func (d Dielectric) microfacet(hit HitRecord, wo Vec3, wi Vec3) (Vec3, Vec3, Vec3, float64, bool) {
+	if d.thin || d.distribution(hit).Smooth() {
+		return Vec3{}, Vec3{}, Vec3{}, 0, false
+	}
+
+	normal, refractionRatio := d.orientation(hit, wo)
+	tangent, bitangent := hit.TangentFrame(normal)
+	localWo := wo.ToBasis(tangent, bitangent, normal)
+	localWi := wi.ToBasis(tangent, bitangent, normal)
+	if localWo.z <= 0 || localWi.z == 0 {
+		return Vec3{}, Vec3{}, Vec3{}, 0, false
+	}
+
+	// Reflections are joined by the half vector, and refractions by the
+	// generalized half vector that Snell's law bends one into the other around
+	reflected := localWi.z > 0
+	halfVector := localWo.Add(localWi)
+	if !reflected {
+		halfVector = localWo.Scale(refractionRatio).Add(localWi)
+	}
+	if halfVector.z < 0 {
+		halfVector = halfVector.Scale(-1)
+	}
+	if halfVector.LengthSquared() == 0 {
+		return Vec3{}, Vec3{}, Vec3{}, 0, false
+	}
+	microfacet := halfVector.Unit()
+
+	// Both directions have to be on the right sides of the microfacet
+	cosO := localWo.Dot(microfacet)
+	cosI := localWi.Dot(microfacet)
+	if cosO <= 0 || (reflected && cosI <= 0) || (!reflected && cosI >= 0) {
+		return Vec3{}, Vec3{}, Vec3{}, 0, false
+	}
+
+	return localWo, localWi, microfacet, refractionRatio, true
+}
+
+// Bend a unit direction through a surface whose normal faces against it, where
+// refractionRatio is the index of refraction being left over the one being
+// entered. Returns false if the light is totally internally reflected instead
+func (d Dielectric) Refract(direction Vec3, normal Vec3, refractionRatio float64) (Vec3, bool) {
+	cosTheta := math.Min(-direction.Dot(normal), 1)
+	sinThetaSquared := 1 - cosTheta*cosTheta
+
+	// Snell's law has no solution past the critical angle
+	if refractionRatio*refractionRatio*sinThetaSquared > 1 {
+		return Vec3{}, false
+	}
+
+	// The perpendicular direction of the exit ray
+	exitPerpendicular := direction.
+		Add(normal.Scale(cosTheta)).
+		Scale(refractionRatio)
+
+	// The parallel direction of the exit ray
+	exitParallel := normal.Scale(
+		-math.Sqrt(1 - exitPerpendicular.LengthSquared()),
+	)
+
+	// Add the perpendicular and parallel components of the exit ray
+	return exitParallel.Add(exitPerpendicular).Unit(), true
+}
+
+// The fraction of light reflected where the surface between two dielectrics
+// meets it at an angle with the given cosine to the normal. Past the critical
+// angle all of the light is reflected
+func dielectricReflectance(cosTheta float64, refractionRatio float64) float64 {
+	sinSquared := refractionRatio * refractionRatio * (1 - cosTheta*cosTheta)
+	if sinSquared >= 1 {
+		return 1
+	}
+
+	// Schlick's approximation wants the angle on the less dense side
+	if refractionRatio > 1 {
+		cosTheta = math.Sqrt(1 - sinSquared)
+	}
+
+	return schlick(cosTheta, refractionRatio)
+}
+
+// Schlick's approximation of the fraction of light a dielectric reflects
+func schlick(cosine float64, refractionRatio float64) float64 {
+	r0 := (1 - refractionRatio) / (1 + refractionRatio)
+	r0 *= r0
+
+	return r0 + (1-r0)*math.Pow(1-cosine, 5)
 }
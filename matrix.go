@@ -0,0 +1,151 @@
+package main
+
+import "math"
+
+// A 4x4 matrix for affine transforms of points and directions, stored by row
+// Points are treated as column vectors with a fourth component of 1, so a
+// translation sits in the last column
+type Matrix [4][4]float64
+
+func IdentityMatrix() Matrix {
+	return Matrix{
+		{1, 0, 0, 0},
+		{0, 1, 0, 0},
+		{0, 0, 1, 0},
+		{0, 0, 0, 1},
+	}
+}
+
+// Move points by the offset
+func TranslationMatrix(offset Vec3) Matrix {
+	m := IdentityMatrix()
+	m[0][3], m[1][3], m[2][3] = offset.x, offset.y, offset.z
+	return m
+}
+
+// Stretch along each axis by the matching component of the scale
+func ScaleMatrix(scale Vec3) Matrix {
+	m := IdentityMatrix()
+	m[0][0], m[1][1], m[2][2] = scale.x, scale.y, scale.z
+	return m
+}
+
+// Turn anticlockwise by the angle in radians about the axis, looking down the
+// axis towards the origin
+func RotationMatrix(axis Vec3, angle float64) Matrix {
+	a := axis.Unit()
+	sin, cos := math.Sincos(angle)
+	c := 1 - cos
+
+	return Matrix{
+		{cos + a.x*a.x*c, a.x*a.y*c - a.z*sin, a.x*a.z*c + a.y*sin, 0},
+		{a.y*a.x*c + a.z*sin, cos + a.y*a.y*c, a.y*a.z*c - a.x*sin, 0},
+		{a.z*a.x*c - a.y*sin, a.z*a.y*c + a.x*sin, cos + a.z*a.z*c, 0},
+		{0, 0, 0, 1},
+	}
+}
+
+// The matrix that applies m2 and then m
+func (m Matrix) Mul(m2 Matrix) Matrix {
+	var result Matrix
+	for i := range 4 {
+		for j := range 4 {
+			for k := range 4 {
+				result[i][j] += m[i][k] * m2[k][j]
+			}
+		}
+	}
+
+	return result
+}
+
+func (m Matrix) Transpose() Matrix {
+	var result Matrix
+	for i := range 4 {
+		for j := range 4 {
+			result[i][j] = m[j][i]
+		}
+	}
+
+	return result
+}
+
+// The matrix that undoes m, found by Gauss-Jordan elimination
+// Returns false if m squashes space flat and can't be undone
+func (m Matrix) Inverse() (Matrix, bool) {
+	inverse := IdentityMatrix()
+
+	for column := range 4 {
+		// Swap up the row with the largest value in this column, which keeps
+		// the division below as accurate as it can be
+		pivot := column
+		for row := column + 1; row < 4; row++ {
+			if math.Abs(m[row][column]) > math.Abs(m[pivot][column]) {
+				pivot = row
+			}
+		}
+		if math.Abs(m[pivot][column]) < 1e-12 {
+			return Matrix{}, false
+		}
+		m[column], m[pivot] = m[pivot], m[column]
+		inverse[column], inverse[pivot] = inverse[pivot], inverse[column]
+
+		scale := 1 / m[column][column]
+		for j := range 4 {
+			m[column][j] *= scale
+			inverse[column][j] *= scale
+		}
+
+		// Clear the column from every other row
+		for row := range 4 {
+			if row == column {
+				continue
+			}
+
+			factor := m[row][column]
+			for j := range 4 {
+				m[row][j] -= factor * m[column][j]
+				inverse[row][j] -= factor * inverse[column][j]
+			}
+		}
+	}
+
+	return inverse, true
+}
+
+// How much the matrix grows volumes by, negative if it also mirrors them
+// Only the upper 3x3 part, which excludes any translation, is used
+func (m Matrix) Determinant() float64 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+// Transform a point, including any translation
+func (m Matrix) Point(p Vec3) Vec3 {
+	return Vec3{
+		m[0][0]*p.x + m[0][1]*p.y + m[0][2]*p.z + m[0][3],
+		m[1][0]*p.x + m[1][1]*p.y + m[1][2]*p.z + m[1][3],
+		m[2][0]*p.x + m[2][1]*p.y + m[2][2]*p.z + m[2][3],
+	}
+}
+
+// Transform a direction, which isn't moved by translation
+func (m Matrix) Direction(v Vec3) Vec3 {
+	return Vec3{
+		m[0][0]*v.x + m[0][1]*v.y + m[0][2]*v.z,
+		m[1][0]*v.x + m[1][1]*v.y + m[1][2]*v.z,
+		m[2][0]*v.x + m[2][1]*v.y + m[2][2]*v.z,
+	}
+}
+
+// Transform a direction by the transpose of the matrix. For the inverse of a
+// transform this carries normals through the transform, keeping them at right
+// angles to the surface however it's stretched
+func (m Matrix) TransposeDirection(v Vec3) Vec3 {
+	return Vec3{
+		m[0][0]*v.x + m[1][0]*v.y + m[2][0]*v.z,
+		m[0][1]*v.x + m[1][1]*v.y + m[2][1]*v.z,
+		m[0][2]*v.x + m[1][2]*v.y + m[2][2]*v.z,
+	}
+}
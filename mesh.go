@@ -0,0 +1,216 @@
+package main
+
+import "math"
+
+// The indices into a mesh's arrays that make up one of its triangles
+type MeshFace struct {
+	vertices [3]int
+	normals  [3]int // -1 when the triangle has no vertex normals
+	uvs      [3]int // -1 when the triangle has no texture coordinates
+	material int    // Index into the mesh's materials
+}
+
+// Implements Object interface
+// Implements Solid interface
+// A triangle mesh
+// The triangles share the mesh's vertex, normal and texture coordinate arrays
+// rather than keeping their own copies, and the mesh keeps its own BVH over
+// them so the scene only has to bound the mesh as a whole
+type Mesh struct {
+	vertices  []Vec3
+	normals   []Vec3
+	uvs       []Vec3 // Texture coordinates, only x and y are used
+	materials []Material
+	triangles []Object
+	bvh       *BVH
+}
+
+// Build a mesh and the acceleration structure over its triangles
+func NewMesh(vertices []Vec3, normals []Vec3, uvs []Vec3, faces []MeshFace, materials []Material) *Mesh {
+	m := &Mesh{
+		vertices:  vertices,
+		normals:   normals,
+		uvs:       uvs,
+		materials: materials,
+	}
+
+	m.triangles = make([]Object, len(faces))
+	for i, f := range faces {
+		m.triangles[i] = Triangle{mesh: m, face: f}
+	}
+
+	m.bvh = NewBVH(m.triangles, false)
+
+	return m
+}
+
+// Give every vertex a normal averaged from the faces around it, so that a mesh
+// is shaded smoothly instead of showing its facets
+// Larger faces have more say in the average. The faces are updated to use the
+// new normals, which share the vertex indices
+func computeVertexNormals(vertices []Vec3, faces []MeshFace) []Vec3 {
+	normals := make([]Vec3, len(vertices))
+
+	for _, f := range faces {
+		v0, v1, v2 := vertices[f.vertices[0]], vertices[f.vertices[1]], vertices[f.vertices[2]]
+
+		// The cross product is as long as twice the area of the face
+		faceNormal := v1.Sub(v0).Cross(v2.Sub(v0))
+		for _, v := range f.vertices {
+			normals[v] = normals[v].Add(faceNormal)
+		}
+	}
+
+	for i, n := range normals {
+		if n.LengthSquared() > 0 {
+			normals[i] = n.Unit()
+		}
+	}
+
+	for i := range faces {
+		faces[i].normals = faces[i].vertices
+	}
+
+	return normals
+}
+
+func (m *Mesh) Center() Vec3 {
+	return m.BoundingBox().Centroid()
+}
+
+func (m *Mesh) BoundingBox() AABB {
+	if len(m.bvh.nodes) == 0 {
+		return EmptyAABB()
+	}
+
+	return m.bvh.nodes[0].box
+}
+
+func (m *Mesh) Hit(r Ray, itv Interval) (HitRecord, bool) {
+	return m.bvh.Hit(r, itv)
+}
+
+func (m *Mesh) Spans(r Ray, itv Interval) []Span {
+	return crossingSpans(m, r, itv)
+}
+
+// Implements Object interface
+// A single triangle of a mesh
+type Triangle struct {
+	mesh *Mesh
+	face MeshFace
+}
+
+// Create a triangle on its own, in a mesh of one triangle
+func NewTriangle(a Vec3, b Vec3, c Vec3, material Material) Triangle {
+	face := MeshFace{
+		vertices: [3]int{0, 1, 2},
+		normals:  [3]int{-1, -1, -1},
+		uvs:      [3]int{-1, -1, -1},
+	}
+	m := NewMesh([]Vec3{a, b, c}, nil, nil, []MeshFace{face}, []Material{material})
+
+	return m.triangles[0].(Triangle)
+}
+
+// The positions of the corners of the triangle
+func (t Triangle) Vertices() (Vec3, Vec3, Vec3) {
+	v := t.mesh.vertices
+	return v[t.face.vertices[0]], v[t.face.vertices[1]], v[t.face.vertices[2]]
+}
+
+func (t Triangle) Material() Material {
+	return t.mesh.materials[t.face.material]
+}
+
+func (t Triangle) Center() Vec3 {
+	v0, v1, v2 := t.Vertices()
+	return v0.Add(v1).Add(v2).Div(3)
+}
+
+func (t Triangle) BoundingBox() AABB {
+	v0, v1, v2 := t.Vertices()
+	return EmptyAABB().Extend(v0).Extend(v1).Extend(v2).Pad(1e-4)
+}
+
+// Intersect the ray with the triangle using the Möller–Trumbore algorithm
+func (t Triangle) Hit(r Ray, itv Interval) (HitRecord, bool) {
+	v0, v1, v2 := t.Vertices()
+	edge1 := v1.Sub(v0)
+	edge2 := v2.Sub(v0)
+
+	// A determinant near zero means the ray runs parallel to the triangle
+	p := r.direction.Cross(edge2)
+	det := edge1.Dot(p)
+	if math.Abs(det) < 1e-12 {
+		return HitRecord{}, false
+	}
+	invDet := 1 / det
+
+	// Find the barycentric coordinates of the point where the ray crosses the
+	// triangle's plane, bailing out as soon as it's clearly outside
+	toOrigin := r.origin.Sub(v0)
+	u := toOrigin.Dot(p) * invDet
+	if u < 0 || u > 1 {
+		return HitRecord{}, false
+	}
+
+	q := toOrigin.Cross(edge1)
+	v := r.direction.Dot(q) * invDet
+	if v < 0 || u+v > 1 {
+		return HitRecord{}, false
+	}
+
+	hitT := edge2.Dot(q) * invDet
+	if !itv.Contains(hitT) {
+		return HitRecord{}, false
+	}
+
+	w := 1 - u - v
+	geometricNormal := edge1.Cross(edge2).Unit()
+	normal := geometricNormal
+
+	// Blend the vertex normals for smooth shading
+	if n := t.face.normals; n[0] >= 0 {
+		normals := t.mesh.normals
+		normal = normals[n[0]].Scale(w).Add(normals[n[1]].Scale(u)).Add(normals[n[2]].Scale(v)).Unit()
+
+		// Keep the surface facing the same way as its vertex normals, whatever
+		// order the vertices were wound in
+		if !geometricNormal.OnPlane(normal) {
+			geometricNormal = geometricNormal.Scale(-1)
+		}
+	}
+
+	// Blend the texture coordinates, falling back on the barycentric ones
+	uv := Vec3{u, v, 0}
+	uv0, uv1, uv2 := Vec3{0, 0, 0}, Vec3{1, 0, 0}, Vec3{0, 1, 0}
+	if i := t.face.uvs; i[0] >= 0 {
+		uvs := t.mesh.uvs
+		uv0, uv1, uv2 = uvs[i[0]], uvs[i[1]], uvs[i[2]]
+		uv = uv0.Scale(w).Add(uv1.Scale(u)).Add(uv2.Scale(v))
+	}
+
+	// Work out how the point moves across the triangle as u and v change by
+	// solving for the edges in terms of their texture coordinates
+	duv1 := uv1.Sub(uv0)
+	duv2 := uv2.Sub(uv0)
+	dpdu, dpdv := edge1, edge2
+	if uvDet := duv1.x*duv2.y - duv1.y*duv2.x; math.Abs(uvDet) > 1e-12 {
+		dpdu = edge1.Scale(duv2.y).Sub(edge2.Scale(duv1.y)).Div(uvDet)
+		dpdv = edge2.Scale(duv1.x).Sub(edge1.Scale(duv2.x)).Div(uvDet)
+	}
+
+	return HitRecord{
+		t:               hitT,
+		point:           r.At(hitT),
+		normal:          normal,
+		geometricNormal: geometricNormal,
+		u:               uv.x,
+		v:               uv.y,
+		dpdu:            dpdu,
+		dpdv:            dpdv,
+		material:        t.Material(),
+		object:          t,
+	}, true
+}
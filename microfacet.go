@@ -0,0 +1,184 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+)
+
+// Surfaces smoother than this are treated as perfect mirrors, since the GGX
+// distribution becomes too sharp to sample or evaluate reliably
+const minAlpha = 1e-3
+
+// The GGX (Trowbridge-Reitz) distribution of microfacet normals on a rough
+// surface. Vectors are in the surface's local space, with the normal along +Z
+// and alphaX and alphaY the roughness along the tangent and bitangent
+type GGX struct {
+	alphaX float64
+	alphaY float64
+}
+
+// The distribution for a perceptual roughness from 0 to 1, squared the way
+// most renderers do so that roughness looks even across its range. Anisotropy
+// from 0 to 1 stretches the highlights along the tangent
+func NewGGX(roughness float64, anisotropy float64) GGX {
+	alpha := roughness * roughness
+	aspect := math.Sqrt(1 - 0.9*anisotropy)
+
+	return GGX{
+		alphaX: math.Max(minAlpha, alpha/aspect),
+		alphaY: math.Max(minAlpha, alpha*aspect),
+	}
+}
+
+// Whether the surface is smooth enough to be treated as a perfect mirror
+func (g GGX) Smooth() bool {
+	return g.alphaX <= minAlpha && g.alphaY <= minAlpha
+}
+
+// The density of microfacets facing along m
+func (g GGX) D(m Vec3) float64 {
+	if m.z <= 0 {
+		return 0
+	}
+
+	e := (m.x*m.x)/(g.alphaX*g.alphaX) + (m.y*m.y)/(g.alphaY*g.alphaY) + m.z*m.z
+	return 1 / (math.Pi * g.alphaX * g.alphaY * e * e)
+}
+
+// Smith's auxiliary function for the microfacets hidden when looking along w
+func (g GGX) lambda(w Vec3) float64 {
+	if w.z == 0 {
+		return math.Inf(1)
+	}
+
+	alphaTanSquared := (g.alphaX*g.alphaX*w.x*w.x + g.alphaY*g.alphaY*w.y*w.y) / (w.z * w.z)
+	return (math.Sqrt(1+alphaTanSquared) - 1) / 2
+}
+
+// The fraction of microfacets visible from w
+func (g GGX) G1(w Vec3) float64 {
+	return 1 / (1 + g.lambda(w))
+}
+
+// The fraction of microfacets visible from both wo and wi, accounting for the
+// correlation between the two through the height of the microfacets
+func (g GGX) G(wo Vec3, wi Vec3) float64 {
+	return 1 / (1 + g.lambda(wo) + g.lambda(wi))
+}
+
+// Pick a microfacet normal in proportion to how much of it can be seen from
+// wo, following Heitz's "Sampling the GGX Distribution of Visible Normals"
+func (g GGX) SampleVisibleNormal(wo Vec3, rng *rand.Rand) Vec3 {
+	// Stretch the view direction to where the distribution is a hemisphere
+	view := Vec3{g.alphaX * wo.x, g.alphaY * wo.y, wo.z}.Unit()
+
+	// Build a basis around the view direction
+	tangent := Vec3{1, 0, 0}
+	if lengthSquared := view.x*view.x + view.y*view.y; lengthSquared > 0 {
+		tangent = Vec3{-view.y, view.x, 0}.Scale(1 / math.Sqrt(lengthSquared))
+	}
+	bitangent := view.Cross(tangent)
+
+	// Pick a point on the projected hemisphere, squashing half of the disk to
+	// account for the part hidden behind the hemisphere
+	r := math.Sqrt(rng.Float64())
+	phi := 2 * math.Pi * rng.Float64()
+	x := r * math.Cos(phi)
+	y := r * math.Sin(phi)
+	s := (1 + view.z) / 2
+	y = (1-s)*math.Sqrt(1-x*x) + s*y
+
+	// Lift the point up onto the hemisphere and unstretch it
+	normal := tangent.Scale(x).
+		Add(bitangent.Scale(y)).
+		Add(view.Scale(math.Sqrt(math.Max(0, 1-x*x-y*y))))
+
+	return Vec3{g.alphaX * normal.x, g.alphaY * normal.y, math.Max(1e-6, normal.z)}.Unit()
+}
+
+// The density with which SampleVisibleNormal picks m when looking from wo
+func (g GGX) VisibleNormalPDF(wo Vec3, m Vec3) float64 {
+	cosine := wo.Dot(m)
+	if cosine <= 0 || wo.z <= 0 {
+		return 0
+	}
+
+	return g.G1(wo) * cosine * g.D(m) / wo.z
+}
+
+// The complex index of refraction of a metal for red, green and blue light
+type Conductor struct {
+	eta Vec3 // The real part, how much the metal bends light
+	k   Vec3 // The imaginary part, how strongly the metal absorbs light
+}
+
+// Measured indices of refraction for common metals, at roughly 650nm, 550nm
+// and 450nm
+var conductors = map[string]Conductor{
+	"gold": {
+		eta: Vec3{0.143119, 0.374957, 1.442479},
+		k:   Vec3{3.983160, 2.385721, 1.603215},
+	},
+	"copper": {
+		eta: Vec3{0.200438, 0.924033, 1.102212},
+		k:   Vec3{3.912949, 2.452848, 2.142188},
+	},
+	"aluminium": {
+		eta: Vec3{1.657460, 0.880369, 0.521229},
+		k:   Vec3{9.223869, 6.269523, 4.837001},
+	},
+	"silver": {
+		eta: Vec3{0.155265, 0.116723, 0.138342},
+		k:   Vec3{4.828181, 3.122249, 2.146961},
+	},
+}
+
+// The names of the conductors, sorted for use in messages
+func conductorNames() string {
+	names := make([]string, 0, len(conductors))
+	for name := range conductors {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return strings.Join(names, ", ")
+}
+
+// The fraction of light the metal reflects at an angle with the given cosine
+// to the normal
+func (c Conductor) Fresnel(cosTheta float64) Vec3 {
+	return Vec3{
+		fresnelConductor(cosTheta, c.eta.x, c.k.x),
+		fresnelConductor(cosTheta, c.eta.y, c.k.y),
+		fresnelConductor(cosTheta, c.eta.z, c.k.z),
+	}
+}
+
+// The exact Fresnel reflectance of unpolarized light off a conductor
+func fresnelConductor(cosTheta float64, eta float64, k float64) float64 {
+	cosSquared := cosTheta * cosTheta
+	sinSquared := 1 - cosSquared
+
+	t0 := eta*eta - k*k - sinSquared
+	aSquaredPlusBSquared := math.Sqrt(t0*t0 + 4*eta*eta*k*k)
+	a := math.Sqrt(math.Max(0, (aSquaredPlusBSquared+t0)/2))
+
+	t1 := aSquaredPlusBSquared + cosSquared
+	t2 := 2 * cosTheta * a
+	perpendicular := (t1 - t2) / (t1 + t2)
+
+	t3 := cosSquared*aSquaredPlusBSquared + sinSquared*sinSquared
+	t4 := t2 * sinSquared
+	parallel := perpendicular * (t3 - t4) / (t3 + t4)
+
+	return (perpendicular + parallel) / 2
+}
+
+// Schlick's approximation of the reflectance of a surface that reflects f0
+// when seen straight on
+func schlickColor(cosTheta float64, f0 Vec3) Vec3 {
+	weight := math.Pow(1-cosTheta, 5)
+	return f0.Add(Vec3{1, 1, 1}.Sub(f0).Scale(weight))
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The material for faces in an OBJ file that don't pick one of their own
+var defaultOBJMaterial Material = Lambertian{
+	albedo: SolidColor{linearFromRGBA(color.RGBA{128, 128, 128, maxColorVal})},
+}
+
+// A run of faces in an OBJ file, started by a g or o statement
+// A g statement can name several groups, and the faces belong to all of them
+type OBJGroup struct {
+	names     []string
+	firstFace int
+	faceCount int
+}
+
+// The geometry and materials read from a Wavefront OBJ file
+// Faces use material -1 until a usemtl statement picks one
+type OBJFile struct {
+	vertices  []Vec3
+	normals   []Vec3
+	uvs       []Vec3
+	faces     []MeshFace
+	materials []Material
+	groups    []OBJGroup
+}
+
+// An error on a particular line of an OBJ or MTL file
+type ParseError struct {
+	Path string
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.Path, e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Read the numbers that follow a statement, which must number between min
+// and max
+func parseFloats(fields []string, min int, max int) ([]float64, error) {
+	if len(fields) < min || len(fields) > max {
+		if min == max {
+			return nil, fmt.Errorf("expected %d numbers, got %d", min, len(fields))
+		}
+		return nil, fmt.Errorf("expected %d to %d numbers, got %d", min, max, len(fields))
+	}
+
+	values := make([]float64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("%q is not a number", f)
+		}
+		values[i] = v
+	}
+
+	return values, nil
+}
+
+// Turn a 1-based, possibly negative (counting back from the end) OBJ index
+// into a 0-based one
+func objIndex(field string, count int) (int, error) {
+	i, err := strconv.Atoi(field)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an index", field)
+	}
+
+	if i < 0 {
+		i += count
+	} else {
+		i--
+	}
+
+	if i < 0 || i >= count {
+		return 0, fmt.Errorf("index %s is out of range, there are only %d so far", field, count)
+	}
+
+	return i, nil
+}
+
+// Load an OBJ file along with any MTL files it names
+func loadOBJ(path string) (*OBJFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseOBJ(path, file)
+}
+
+// Parse an OBJ file, triangulating any polygons with more than three sides
+func parseOBJ(path string, r io.Reader) (*OBJFile, error) {
+	obj := &OBJFile{}
+	materialIndices := map[string]int{}
+	currentMaterial := -1
+
+	// Faces before the first g or o belong to the default group
+	obj.groups = append(obj.groups, OBJGroup{names: []string{"default"}})
+	startGroup := func(names []string) {
+		last := &obj.groups[len(obj.groups)-1]
+		if last.faceCount == 0 {
+			last.names = names // Nothing was put in the last group, so reuse it
+			return
+		}
+		obj.groups = append(obj.groups, OBJGroup{names: names, firstFace: len(obj.faces)})
+	}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fail := func(format string, args ...any) error {
+			return &ParseError{Path: path, Line: line, Err: fmt.Errorf(format, args...)}
+		}
+
+		// Drop comments and split the statement from its arguments
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		keyword, args := fields[0], fields[1:]
+
+		switch keyword {
+		case "v":
+			// An optional fourth coordinate (w) is allowed but ignored
+			values, err := parseFloats(args, 3, 4)
+			if err != nil {
+				return nil, fail("v: %v", err)
+			}
+			obj.vertices = append(obj.vertices, Vec3{values[0], values[1], values[2]})
+
+		case "vt":
+			values, err := parseFloats(args, 1, 3)
+			if err != nil {
+				return nil, fail("vt: %v", err)
+			}
+			uv := Vec3{values[0], 0, 0}
+			if len(values) > 1 {
+				uv.y = values[1]
+			}
+			obj.uvs = append(obj.uvs, uv)
+
+		case "vn":
+			values, err := parseFloats(args, 3, 3)
+			if err != nil {
+				return nil, fail("vn: %v", err)
+			}
+			normal := Vec3{values[0], values[1], values[2]}
+			if normal.LengthSquared() == 0 {
+				return nil, fail("vn: the normal has no length")
+			}
+			obj.normals = append(obj.normals, normal.Unit())
+
+		case "f":
+			if len(args) < 3 {
+				return nil, fail("f: a face needs at least 3 vertices, got %d", len(args))
+			}
+
+			// Each corner is v, v/vt, v//vn or v/vt/vn
+			type corner struct {
+				vertex, uv, normal int
+			}
+			corners := make([]corner, len(args))
+			for i, arg := range args {
+				parts := strings.Split(arg, "/")
+				if len(parts) > 3 {
+					return nil, fail("f: %q has too many parts", arg)
+				}
+
+				c := corner{uv: -1, normal: -1}
+
+				var err error
+				if c.vertex, err = objIndex(parts[0], len(obj.vertices)); err != nil {
+					return nil, fail("f: vertex %v", err)
+				}
+				if len(parts) > 1 && parts[1] != "" {
+					if c.uv, err = objIndex(parts[1], len(obj.uvs)); err != nil {
+						return nil, fail("f: texture coordinate %v", err)
+					}
+				}
+				if len(parts) > 2 && parts[2] != "" {
+					if c.normal, err = objIndex(parts[2], len(obj.normals)); err != nil {
+						return nil, fail("f: normal %v", err)
+					}
+				}
+
+				// Every corner has to agree on which attributes it has
+				if i > 0 && ((c.uv < 0) != (corners[0].uv < 0) || (c.normal < 0) != (corners[0].normal < 0)) {
+					return nil, fail("f: %q doesn't have the same parts as %q", arg, args[0])
+				}
+
+				corners[i] = c
+			}
+
+			// Split the polygon into a fan of triangles around its first corner
+			for i := 1; i+1 < len(corners); i++ {
+				face := MeshFace{material: currentMaterial}
+				for j, c := range [3]corner{corners[0], corners[i], corners[i+1]} {
+					face.vertices[j] = c.vertex
+					face.normals[j] = c.normal
+					face.uvs[j] = c.uv
+				}
+				obj.faces = append(obj.faces, face)
+				obj.groups[len(obj.groups)-1].faceCount++
+			}
+
+		case "g":
+			if len(args) == 0 {
+				startGroup([]string{"default"})
+			} else {
+				startGroup(args)
+			}
+
+		case "o":
+			// Objects only have one name, which may have spaces in it
+			startGroup([]string{strings.Join(args, " ")})
+
+		case "mtllib":
+			if len(args) == 0 {
+				return nil, fail("mtllib: expected a file name")
+			}
+
+			// Material libraries are found next to the OBJ file
+			for _, name := range args {
+				materials, err := loadMTL(filepath.Join(filepath.Dir(path), name))
+				if err != nil {
+					return nil, fail("mtllib: %v", err)
+				}
+
+				for _, m := range materials {
+					materialIndices[m.name] = len(obj.materials)
+					obj.materials = append(obj.materials, m.material)
+				}
+			}
+
+		case "usemtl":
+			if len(args) != 1 {
+				return nil, fail("usemtl: expected one material name, got %d", len(args))
+			}
+
+			index, ok := materialIndices[args[0]]
+			if !ok {
+				return nil, fail("usemtl: unknown material %q", args[0])
+			}
+			currentMaterial = index
+
+		case "s", "l", "p", "vp":
+			// Smoothing groups, lines, points and free-form geometry don't
+			// mean anything for rendering triangles
+
+		default:
+			return nil, fail("unknown statement %q", keyword)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(obj.faces) == 0 {
+		return nil, fmt.Errorf("%s: no faces", path)
+	}
+
+	return obj, nil
+}
+
+// Build a mesh from some of the groups in the file, or all of them if none are
+// named
+// Faces without a material of their own use defaultMaterial, and smooth fills
+// in vertex normals when the file has none
+func (obj *OBJFile) Mesh(groups []string, defaultMaterial Material, smooth bool) (*Mesh, error) {
+	wanted := map[string]bool{}
+	for _, name := range groups {
+		wanted[name] = true
+	}
+
+	// A group can come back later in the file, so every run of faces is
+	// checked even after a group has been found
+	found := map[string]bool{}
+	faces := []MeshFace{}
+	for _, g := range obj.groups {
+		if len(groups) > 0 && !slices.ContainsFunc(g.names, func(name string) bool { return wanted[name] }) {
+			continue
+		}
+		for _, name := range g.names {
+			found[name] = true
+		}
+		faces = append(faces, obj.faces[g.firstFace:g.firstFace+g.faceCount]...)
+	}
+
+	for _, name := range groups {
+		if !found[name] {
+			return nil, fmt.Errorf("no group named %q", name)
+		}
+	}
+	if len(faces) == 0 {
+		return nil, fmt.Errorf("the selected groups have no faces")
+	}
+
+	// The default material goes on the end of the list
+	materials := append(append([]Material{}, obj.materials...), defaultMaterial)
+	for i := range faces {
+		if faces[i].material < 0 {
+			faces[i].material = len(materials) - 1
+		}
+	}
+
+	normals := obj.normals
+	if smooth && len(normals) == 0 {
+		normals = computeVertexNormals(obj.vertices, faces)
+	}
+
+	return NewMesh(obj.vertices, normals, obj.uvs, faces, materials), nil
+}
+
+// A material read from an MTL file
+type namedMaterial struct {
+	name     string
+	material Material
+}
+
+// Load the materials in an MTL file
+func loadMTL(path string) ([]namedMaterial, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseMTL(path, file)
+}
+
+// Parse an MTL file, mapping its properties onto our materials
+//   - d (dissolve) or Tr (transparency) below fully opaque makes a dielectric
+//     with Ni (optical density) as its refraction index, tinted by Tf
+//   - otherwise a Ks (specular color) brighter than Kd (diffuse color) makes a
+//     metal of that color, with Ns (specular exponent) setting its roughness
+//   - otherwise the material is Lambertian with Kd as its color, or the image
+//     named by map_Kd, found relative to the MTL file
+//   - Ke (emissive color) makes any of them give off light
+//   - norm names a tangent space normal map, and bump or map_Bump a bump map
+//     whose heights are scaled by its -bm option
+func parseMTL(path string, r io.Reader) ([]namedMaterial, error) {
+	materials := []namedMaterial{}
+
+	// What has been read for the current material
+	type mtlState struct {
+		diffuse      Vec3
+		emission     Vec3
+		specular     Vec3
+		transmission Vec3
+		diffuseMap   Texture
+		normalMap    Texture
+		bump         Texture
+		bumpHeight   float64
+		exponent     float64
+		dissolve     float64
+		density      float64
+	}
+	var current *mtlState
+
+	// Turn what has been read into a material
+	finish := func() {
+		if current == nil {
+			return
+		}
+
+		// Emission can be brighter than white, so only the color goes through
+		// the sRGB decoding and the brightness goes in the intensity
+		emission := Emission{}
+		if brightest := math.Max(current.emission.x, math.Max(current.emission.y, current.emission.z)); brightest > 0 {
+			emission = Emission{
+				emission:          linearFromSRGB(current.emission.Div(brightest)),
+				emissionIntensity: brightest,
+			}
+		}
+
+		detail := Detail{normalMap: current.normalMap, bump: current.bump, bumpHeight: current.bumpHeight}
+
+		// Anything see-through is glass, anything with a stronger specular
+		// than diffuse color is metal and everything else is matte
+		var m Material
+		switch {
+		case current.dissolve < 1:
+			m = Dielectric{
+				Emission:        emission,
+				Detail:          detail,
+				tint:            SolidColor{linearFromSRGB(current.transmission)},
+				refractionIndex: current.density,
+				roughness:       SolidColor{},
+			}
+		case luminance(current.specular) > luminance(current.diffuse):
+			// Shiny materials get less rough as their specular exponent goes
+			// up, using the usual conversion from a Phong exponent to a
+			// microfacet alpha, which is the square of the roughness
+			alpha := math.Sqrt(2 / (current.exponent + 2))
+			m = Metal{
+				Emission:  emission,
+				Detail:    detail,
+				albedo:    SolidColor{linearFromSRGB(current.specular)},
+				roughness: SolidColor{Vec3{1, 1, 1}.Scale(Interval{0, 1}.Clamp(math.Sqrt(alpha)))},
+			}
+		default:
+			var albedo Texture = SolidColor{linearFromSRGB(current.diffuse)}
+			if current.diffuseMap != nil {
+				albedo = current.diffuseMap
+			}
+			m = Lambertian{Emission: emission, Detail: detail, albedo: albedo}
+		}
+
+		materials[len(materials)-1].material = m
+	}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fail := func(format string, args ...any) error {
+			return &ParseError{Path: path, Line: line, Err: fmt.Errorf(format, args...)}
+		}
+
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		keyword, args := fields[0], fields[1:]
+
+		if keyword == "newmtl" {
+			if len(args) != 1 {
+				return nil, fail("newmtl: expected one material name, got %d", len(args))
+			}
+
+			finish()
+			materials = append(materials, namedMaterial{name: args[0]})
+			current = &mtlState{diffuse: Vec3{0.8, 0.8, 0.8}, transmission: Vec3{1, 1, 1}, bumpHeight: 0.01, dissolve: 1, density: 1}
+			continue
+		}
+
+		if current == nil {
+			return nil, fail("%s: comes before any newmtl", keyword)
+		}
+
+		// Colors are usually three numbers, but one number means grey
+		readColor := func() (Vec3, error) {
+			values, err := parseFloats(args, 1, 3)
+			if err != nil {
+				return Vec3{}, fmt.Errorf("%s: %v", keyword, err)
+			}
+			if len(values) == 2 {
+				return Vec3{}, fmt.Errorf("%s: expected 1 or 3 numbers, got 2", keyword)
+			}
+			if len(values) == 1 {
+				return Vec3{values[0], values[0], values[0]}, nil
+			}
+			return Vec3{values[0], values[1], values[2]}, nil
+		}
+
+		readNumber := func(min float64, max float64) (float64, error) {
+			values, err := parseFloats(args, 1, 1)
+			if err != nil {
+				return 0, fmt.Errorf("%s: %v", keyword, err)
+			}
+			if values[0] < min || values[0] > max {
+				return 0, fmt.Errorf("%s: must be between %v and %v, got %v", keyword, min, max, values[0])
+			}
+			return values[0], nil
+		}
+
+		// Texture maps name their image last, after any options
+		readMap := func(linear bool) (Texture, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("%s: expected a file name", keyword)
+			}
+
+			texture, err := loadImageTexture(filepath.Join(filepath.Dir(path), args[len(args)-1]), linear, repeatWrap)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", keyword, err)
+			}
+			return texture, nil
+		}
+
+		var err error
+		switch keyword {
+		case "Kd":
+			current.diffuse, err = readColor()
+		case "Ks":
+			current.specular, err = readColor()
+		case "Tf":
+			current.transmission, err = readColor()
+		case "Ke":
+			current.emission, err = readColor()
+		case "Ns":
+			current.exponent, err = readNumber(0, 1000)
+		case "d":
+			current.dissolve, err = readNumber(0, 1)
+		case "Tr":
+			var tr float64
+			tr, err = readNumber(0, 1)
+			current.dissolve = 1 - tr
+		case "Ni":
+			current.density, err = readNumber(0.001, 10)
+		case "map_Kd":
+			current.diffuseMap, err = readMap(false)
+		case "norm":
+			current.normalMap, err = readMap(true)
+		case "bump", "map_Bump":
+			current.bump, err = readMap(true)
+
+			// Bump maps can scale their heights with -bm
+			for i := 0; err == nil && i+1 < len(args)-1; i++ {
+				if args[i] == "-bm" {
+					current.bumpHeight, err = strconv.ParseFloat(args[i+1], 64)
+					if err != nil {
+						err = fmt.Errorf("%s: bad -bm value %q", keyword, args[i+1])
+					}
+				}
+			}
+		case "Ka", "illum", "sharpness", "Pr", "Pm", "Ps", "Pc", "Pcr", "aniso", "anisor":
+			// Properties we have nothing to map onto yet
+		default:
+			// Texture maps and options for them
+			if !strings.HasPrefix(keyword, "map_") && keyword != "bump" && keyword != "disp" && keyword != "decal" && keyword != "refl" && keyword != "norm" {
+				err = fmt.Errorf("unknown statement %q", keyword)
+			}
+		}
+
+		if err != nil {
+			return nil, fail("%v", err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	finish()
+
+	return materials, nil
+}
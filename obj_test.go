@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOBJGroups(t *testing.T) {
+	source := `
+v 0 0 0
+v 1 0 0
+v 0 1 0
+g body
+f 1 2 3
+g head hat
+f 1 2 3
+f 1 2 3
+g body
+f 1 2 3
+o the lid
+f 1 2 3
+`
+	obj, err := parseOBJ("test.obj", strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		groups []string
+		faces  int
+		err    string
+	}{
+		{nil, 5, ""},
+		{[]string{"body"}, 2, ""},
+		{[]string{"head"}, 2, ""},
+		{[]string{"hat"}, 2, ""},
+		{[]string{"body", "hat"}, 4, ""},
+		{[]string{"the lid"}, 1, ""},
+		{[]string{"body", "legs", "arms"}, 0, `no group named "legs"`},
+	}
+
+	for _, test := range tests {
+		mesh, err := obj.Mesh(test.groups, defaultOBJMaterial, false)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("groups %q: got error %v, want %q", test.groups, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("groups %q: %v", test.groups, err)
+			continue
+		}
+		if len(mesh.triangles) != test.faces {
+			t.Errorf("groups %q: got %d faces, want %d", test.groups, len(mesh.triangles), test.faces)
+		}
+	}
+}
@@ -1,14 +1,36 @@
 package main
 
-import "image/color"
-
 type Object interface {
 	Center() Vec3
-	Color() color.RGBA
-	Roughness() float64
-	Transparency() float64
-	Hit(Ray, Interval) float64
-	Normal(r Ray, t float64) Vec3
-	UnitNormal(r Ray, t float64) Vec3
-	Refract(direction Vec3, normal Vec3, hitFront bool) Vec3
+	Hit(Ray, Interval) (HitRecord, bool)
+	BoundingBox() AABB
+}
+
+// Everything needed to shade the point where a ray hit an object
+type HitRecord struct {
+	t               float64 // How far along the ray the hit is
+	point           Vec3
+	normal          Vec3 // The unit normal used for shading, which may be interpolated
+	geometricNormal Vec3 // The unit normal of the actual surface
+	u, v            float64
+	dpdu, dpdv      Vec3 // How far the point moves as u and v increase, along the surface
+	material        Material
+	object          Object // The primitive that was hit
+}
+
+// An object enclosing a space, which can list every stretch of a ray inside
+// it within an interval rather than only the nearest hit, so that it can be
+// combined with others
+type Solid interface {
+	Object
+	Spans(Ray, Interval) []Span
+}
+
+// A stretch of a ray inside a solid, from the hit where the ray goes in to the
+// hit where it comes out, with normals facing out of the solid
+// Spans going in before the interval they were found in, or coming out after
+// it, have an infinite t at that end with nothing else in the record
+type Span struct {
+	in  HitRecord
+	out HitRecord
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The quality used when encoding JPEG images
+var jpegQuality = 95
+
+// Encodes an image into a writer
+type imageEncoder func(io.Writer, image.Image) error
+
+// Choose an image encoder from the extension of the output path
+func encoderFor(path string) (imageEncoder, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		return png.Encode, nil
+	case ".jpg", ".jpeg":
+		return func(w io.Writer, img image.Image) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported image format %q (use .png, .jpg or .jpeg)", filepath.Ext(path))
+}
+
+// Encode an image to a file, choosing the format from the file extension
+func writeImage(path string, img image.Image) error {
+	encode, err := encoderFor(path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = encode(file, img)
+
+	// Always close the file, but report the first error
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(path) // Don't leave a partial image behind
+	}
+
+	return err
+}
+
+// Render a single frame without opening a window and write it to a file
+func renderHeadless(path string) error {
+	// Fail before rendering rather than after if the format is unknown
+	if _, err := encoderFor(path); err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	// Render straight into an image the size of the requested screen
+	pixelBuffer := image.NewRGBA(image.Rect(0, 0, screenWidth, screenHeight))
+	raytracedScene(pixelBuffer, createCamera())
+
+	fmt.Printf("Render took %dms\n", time.Since(start).Milliseconds())
+	if printStats {
+		fmt.Println(world.Stats())
+	}
+
+	return writeImage(path, pixelBuffer)
+}
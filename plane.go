@@ -0,0 +1,157 @@
+package main
+
+import "math"
+
+// Implements Object interface
+// Implements Solid interface
+// A flat surface going on forever through a point, facing along its normal
+// Texture coordinates measure distance across the plane, so textures repeat
+// once per unit
+type Plane struct {
+	point     Vec3
+	normal    Vec3
+	tangent   Vec3 // The direction u increases along
+	bitangent Vec3 // The direction v increases along
+	material  Material
+}
+
+func NewPlane(point Vec3, normal Vec3, material Material) Plane {
+	normal = normal.Unit()
+	tangent, bitangent := normal.Basis()
+
+	return Plane{
+		point:     point,
+		normal:    normal,
+		tangent:   tangent,
+		bitangent: bitangent,
+		material:  material,
+	}
+}
+
+func (p Plane) Center() Vec3 {
+	return p.point
+}
+
+// Planes have no edges, so nothing short of everywhere contains them
+func (p Plane) BoundingBox() AABB {
+	inf := math.Inf(1)
+	return AABB{
+		min: Vec3{-inf, -inf, -inf},
+		max: Vec3{inf, inf, inf},
+	}
+}
+
+func (p Plane) Hit(r Ray, itv Interval) (HitRecord, bool) {
+	t, ok := hitPlane(r, itv, p.point, p.normal)
+	if !ok {
+		return HitRecord{}, false
+	}
+
+	point := r.At(t)
+	offset := point.Sub(p.point)
+
+	return HitRecord{
+		t:               t,
+		point:           point,
+		normal:          p.normal,
+		geometricNormal: p.normal,
+		u:               offset.Dot(p.tangent),
+		v:               offset.Dot(p.bitangent),
+		dpdu:            p.tangent,
+		dpdv:            p.bitangent,
+		material:        p.material,
+		object:          p,
+	}, true
+}
+
+func (p Plane) Spans(r Ray, itv Interval) []Span {
+	return crossingSpans(p, r, itv)
+}
+
+// Where the ray crosses the plane through the point with the given normal, if
+// it does so within the interval
+func hitPlane(r Ray, itv Interval, point Vec3, normal Vec3) (float64, bool) {
+	// Rays running along the plane never cross it
+	denominator := normal.Dot(r.direction)
+	if math.Abs(denominator) < 1e-12 {
+		return 0, false
+	}
+
+	t := normal.Dot(point.Sub(r.origin)) / denominator
+	if !itv.Contains(t) {
+		return 0, false
+	}
+
+	return t, true
+}
+
+// Implements Object interface
+// A flat round disk facing along its normal
+// Texture coordinates run from 0 to 1 across the square the disk fits in, as
+// if the texture had been stuck on flat
+type Disk struct {
+	center    Vec3
+	normal    Vec3
+	radius    float64
+	tangent   Vec3 // The direction u increases along
+	bitangent Vec3 // The direction v increases along
+	material  Material
+}
+
+func NewDisk(center Vec3, normal Vec3, radius float64, material Material) Disk {
+	normal = normal.Unit()
+	tangent, bitangent := normal.Basis()
+
+	return Disk{
+		center:    center,
+		normal:    normal,
+		radius:    radius,
+		tangent:   tangent,
+		bitangent: bitangent,
+		material:  material,
+	}
+}
+
+func (d Disk) Center() Vec3 {
+	return d.center
+}
+
+// The box around the disk, which only reaches as far along each axis as the
+// disk's tilt allows
+func (d Disk) BoundingBox() AABB {
+	extent := Vec3{
+		d.radius * math.Sqrt(math.Max(0, 1-d.normal.x*d.normal.x)),
+		d.radius * math.Sqrt(math.Max(0, 1-d.normal.y*d.normal.y)),
+		d.radius * math.Sqrt(math.Max(0, 1-d.normal.z*d.normal.z)),
+	}
+
+	return AABB{min: d.center.Sub(extent), max: d.center.Add(extent)}.Pad(1e-4)
+}
+
+func (d Disk) Hit(r Ray, itv Interval) (HitRecord, bool) {
+	t, ok := hitPlane(r, itv, d.center, d.normal)
+	if !ok {
+		return HitRecord{}, false
+	}
+
+	point := r.At(t)
+	offset := point.Sub(d.center)
+	if offset.LengthSquared() > d.radius*d.radius {
+		return HitRecord{}, false
+	}
+
+	diameter := 2 * d.radius
+
+	return HitRecord{
+		t:               t,
+		point:           point,
+		normal:          d.normal,
+		geometricNormal: d.normal,
+		u:               0.5 + offset.Dot(d.tangent)/diameter,
+		v:               0.5 + offset.Dot(d.bitangent)/diameter,
+		dpdu:            d.tangent.Scale(diameter),
+		dpdv:            d.bitangent.Scale(diameter),
+		material:        d.material,
+		object:          d,
+	}, true
+}
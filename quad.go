@@ -0,0 +1,128 @@
+package main
+
+// Implements Object interface
+// A parallelogram with one corner at corner and sides along edgeU and edgeV,
+// facing along edgeU × edgeV. Texture coordinates run from 0 to 1 along each
+// edge
+type Quad struct {
+	corner   Vec3
+	edgeU    Vec3
+	edgeV    Vec3
+	normal   Vec3
+	w        Vec3 // Turns a point on the quad's plane into its u and v
+	material Material
+}
+
+func NewQuad(corner Vec3, edgeU Vec3, edgeV Vec3, material Material) Quad {
+	n := edgeU.Cross(edgeV)
+
+	return Quad{
+		corner:   corner,
+		edgeU:    edgeU,
+		edgeV:    edgeV,
+		normal:   n.Unit(),
+		w:        n.Div(n.LengthSquared()),
+		material: material,
+	}
+}
+
+func (q Quad) Center() Vec3 {
+	return q.corner.Add(q.edgeU.Scale(0.5)).Add(q.edgeV.Scale(0.5))
+}
+
+func (q Quad) BoundingBox() AABB {
+	return EmptyAABB().
+		Extend(q.corner).
+		Extend(q.corner.Add(q.edgeU)).
+		Extend(q.corner.Add(q.edgeV)).
+		Extend(q.corner.Add(q.edgeU).Add(q.edgeV)).
+		Pad(1e-4)
+}
+
+// The area of the quad
+func (q Quad) Area() float64 {
+	return q.edgeU.Cross(q.edgeV).Length()
+}
+
+func (q Quad) Hit(r Ray, itv Interval) (HitRecord, bool) {
+	t, ok := hitPlane(r, itv, q.corner, q.normal)
+	if !ok {
+		return HitRecord{}, false
+	}
+
+	// Find how far along each edge the point is
+	point := r.At(t)
+	offset := point.Sub(q.corner)
+	u := q.w.Dot(offset.Cross(q.edgeV))
+	v := q.w.Dot(q.edgeU.Cross(offset))
+	if u < 0 || u > 1 || v < 0 || v > 1 {
+		return HitRecord{}, false
+	}
+
+	return HitRecord{
+		t:               t,
+		point:           point,
+		normal:          q.normal,
+		geometricNormal: q.normal,
+		u:               u,
+		v:               v,
+		dpdu:            q.edgeU,
+		dpdv:            q.edgeV,
+		material:        q.material,
+		object:          q,
+	}, true
+}
+
+// Implements Object interface
+// Implements Solid interface
+// An axis-aligned box made of six quads facing outwards
+type Box struct {
+	box   AABB
+	sides [6]Object
+}
+
+// The box with opposite corners a and b
+func NewBox(a Vec3, b Vec3, material Material) Box {
+	lo, hi := a.Min(b), a.Max(b)
+	size := hi.Sub(lo)
+	dx, dy, dz := Vec3{size.x, 0, 0}, Vec3{0, size.y, 0}, Vec3{0, 0, size.z}
+
+	return Box{
+		box: AABB{min: lo, max: hi},
+		sides: [6]Object{
+			NewQuad(Vec3{lo.x, lo.y, hi.z}, dx, dy, material),           // Front
+			NewQuad(Vec3{hi.x, lo.y, hi.z}, dz.Scale(-1), dy, material), // Right
+			NewQuad(Vec3{hi.x, lo.y, lo.z}, dx.Scale(-1), dy, material), // Back
+			NewQuad(Vec3{lo.x, lo.y, lo.z}, dz, dy, material),           // Left
+			NewQuad(Vec3{lo.x, hi.y, hi.z}, dx, dz.Scale(-1), material), // Top
+			NewQuad(Vec3{lo.x, lo.y, lo.z}, dx, dz, material),           // Bottom
+		},
+	}
+}
+
+func (b Box) Center() Vec3 {
+	return b.box.Centroid()
+}
+
+func (b Box) BoundingBox() AABB {
+	return b.box.Pad(1e-4)
+}
+
+func (b Box) Hit(r Ray, itv Interval) (HitRecord, bool) {
+	var closest HitRecord
+	hitAnything := false
+
+	for _, side := range b.sides {
+		if rec, ok := side.Hit(r, itv); ok {
+			itv.max = rec.t
+			closest = rec
+			hitAnything = true
+		}
+	}
+
+	return closest, hitAnything
+}
+
+func (b Box) Spans(r Ray, itv Interval) []Span {
+	return crossingSpans(b, r, itv)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+// Coefficients closer to zero than this are treated as zero by the solvers,
+// and leading coefficients this much smaller than the rest are dropped
+const rootEpsilon = 1e-9
+
+// Whether the leading coefficient is so much smaller than the rest that
+// dividing by it would only amplify rounding errors
+func negligible(leading float64, rest ...float64) bool {
+	largest := 0.0
+	for _, c := range rest {
+		largest = math.Max(largest, math.Abs(c))
+	}
+
+	return math.Abs(leading) <= rootEpsilon*largest || leading == 0
+}
+
+// The real roots of ax² + bx + c, smallest first
+func solveQuadratic(a float64, b float64, c float64) []float64 {
+	if negligible(a, b, c) {
+		if negligible(b, c) {
+			return nil
+		}
+		return []float64{-c / b}
+	}
+
+	discriminant := b*b - 4*a*c
+	if discriminant < 0 {
+		return nil
+	}
+
+	// Avoid subtracting nearly equal numbers, which loses precision in the
+	// smaller root
+	q := -0.5 * (b + math.Copysign(math.Sqrt(discriminant), b))
+	if q == 0 {
+		return []float64{0}
+	}
+
+	roots := []float64{q / a, c / q}
+	slices.Sort(roots)
+	return roots
+}
+
+// The real roots of ax³ + bx² + cx + d, smallest first, following Schwarze's
+// "Cubic and Quartic Roots" in Graphics Gems
+func solveCubic(a float64, b float64, c float64, d float64) []float64 {
+	if negligible(a, b, c, d) {
+		return solveQuadratic(b, c, d)
+	}
+
+	// Divide through by a, then substitute x = y - A/3 to remove the squared
+	// term, leaving y³ + 3py + 2q = 0
+	A, B, C := b/a, c/a, d/a
+	p := (B - A*A/3) / 3
+	q := (2*A*A*A/27 - A*B/3 + C) / 2
+	discriminant := q*q + p*p*p
+
+	var roots []float64
+	switch {
+	case math.Abs(discriminant) < rootEpsilon:
+		if math.Abs(q) < rootEpsilon {
+			roots = []float64{0}
+		} else {
+			u := math.Cbrt(-q)
+			roots = []float64{2 * u, -u}
+		}
+	case discriminant < 0:
+		// Three real roots, found with trigonometry
+		phi := math.Acos(Interval{-1, 1}.Clamp(-q/math.Sqrt(-p*p*p))) / 3
+		t := 2 * math.Sqrt(-p)
+		roots = []float64{t * math.Cos(phi), -t * math.Cos(phi+math.Pi/3), -t * math.Cos(phi-math.Pi/3)}
+	default:
+		// One real root
+		sqrtD := math.Sqrt(discriminant)
+		roots = []float64{math.Cbrt(sqrtD-q) - math.Cbrt(sqrtD+q)}
+	}
+
+	for i := range roots {
+		roots[i] -= A / 3
+	}
+	slices.Sort(roots)
+	return roots
+}
+
+// The real roots of ax⁴ + bx³ + cx² + dx + e, smallest first, using Ferrari's
+// method and then polishing each root with a few steps of Newton's method to
+// win back the precision lost along the way
+func solveQuartic(a float64, b float64, c float64, d float64, e float64) []float64 {
+	if negligible(a, b, c, d, e) {
+		return solveCubic(b, c, d, e)
+	}
+
+	// Divide through by a, then substitute x = y - A/4 to remove the cubed
+	// term, leaving y⁴ + py² + qy + r = 0
+	A, B, C, D := b/a, c/a, d/a, e/a
+	p := -3*A*A/8 + B
+	q := A*A*A/8 - A*B/2 + C
+	r := -3*A*A*A*A/256 + A*A*B/16 - A*C/4 + D
+
+	var roots []float64
+	if math.Abs(r) < rootEpsilon {
+		// y(y³ + py + q) = 0
+		roots = append(solveCubic(1, 0, p, q), 0)
+	} else {
+		// Any root of the resolvent cubic splits the quartic into two
+		// quadratics
+		resolvent := solveCubic(1, -p/2, -r, r*p/2-q*q/8)
+		if len(resolvent) == 0 {
+			return nil
+		}
+		z := resolvent[len(resolvent)-1]
+
+		u := z*z - r
+		v := 2*z - p
+		switch {
+		case math.Abs(u) < rootEpsilon:
+			u = 0
+		case u > 0:
+			u = math.Sqrt(u)
+		default:
+			return nil
+		}
+		switch {
+		case math.Abs(v) < rootEpsilon:
+			v = 0
+		case v > 0:
+			v = math.Sqrt(v)
+		default:
+			return nil
+		}
+		if q < 0 {
+			v = -v
+		}
+
+		roots = append(solveQuadratic(1, v, z-u), solveQuadratic(1, -v, z+u)...)
+	}
+
+	for i, y := range roots {
+		x := y - A/4
+
+		for range 2 {
+			value := (((x+A)*x+B)*x+C)*x + D
+			slope := ((4*x+3*A)*x+2*B)*x + C
+			if slope == 0 {
+				break
+			}
+			x -= value / slope
+		}
+		roots[i] = x
+	}
+
+	slices.Sort(roots)
+	return roots
+}
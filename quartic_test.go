@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// Whether the roots match the expected ones, in order, to within tolerance
+func rootsMatch(got []float64, want []float64, tolerance float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if math.Abs(got[i]-want[i]) > tolerance {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestSolveQuadratic(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b, c float64
+		want    []float64
+	}{
+		{"two roots", 1, -3, 2, []float64{1, 2}},
+		{"negative leading coefficient", -2, 0, 8, []float64{-2, 2}},
+		{"repeated root", 1, -4, 4, []float64{2, 2}},
+		{"no real roots", 1, 0, 1, nil},
+		{"root at zero", 1, -5, 0, []float64{0, 5}},
+		{"tiny roots", 1, -3e-8, 2e-16, []float64{1e-8, 2e-8}},
+		{"linear", 0, 2, -4, []float64{2}},
+		{"leading coefficient near zero", 1e-20, 2, -4, []float64{2}},
+		{"constant", 0, 0, 3, nil},
+		{"small but scaled", 1e-12, -3e-12, 2e-12, []float64{1, 2}},
+	}
+
+	for _, test := range tests {
+		if got := solveQuadratic(test.a, test.b, test.c); !rootsMatch(got, test.want, 1e-9) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSolveCubic(t *testing.T) {
+	tests := []struct {
+		name       string
+		a, b, c, d float64
+		want       []float64
+	}{
+		{"three roots", 1, -6, 11, -6, []float64{1, 2, 3}},
+		{"one root", 1, 0, 0, -8, []float64{2}},
+		{"double root", 1, -4, 5, -2, []float64{1, 1, 2}},
+		{"triple root", 1, -3, 3, -1, []float64{1}},
+		{"scaled", -2, 12, -22, 12, []float64{1, 2, 3}},
+		{"leading coefficient near zero", 1e-20, 1, -3, 2, []float64{1, 2}},
+	}
+
+	for _, test := range tests {
+		got := solveCubic(test.a, test.b, test.c, test.d)
+
+		// A repeated root can be reported once or as many times as it repeats
+		if !rootsMatch(got, test.want, 1e-6) && !rootsMatch(dedupe(got, 1e-6), dedupe(test.want, 1e-6), 1e-6) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSolveQuartic(t *testing.T) {
+	tests := []struct {
+		name          string
+		a, b, c, d, e float64
+		want          []float64
+	}{
+		{"four roots", 1, -10, 35, -50, 24, []float64{1, 2, 3, 4}},
+		{"two roots", 1, 0, 0, 0, -1, []float64{-1, 1}},
+		{"no real roots", 1, 0, 0, 0, 1, nil},
+		{"double roots", 1, 0, -2, 0, 1, []float64{-1, -1, 1, 1}},
+		{"root at zero", 1, -6, 11, -6, 0, []float64{0, 1, 2, 3}},
+		{"scaled", 3, -30, 105, -150, 72, []float64{1, 2, 3, 4}},
+		{"leading coefficient near zero", 1e-20, 1, -6, 11, -6, []float64{1, 2, 3}},
+	}
+
+	for _, test := range tests {
+		got := solveQuartic(test.a, test.b, test.c, test.d, test.e)
+		if !rootsMatch(got, test.want, 1e-6) && !rootsMatch(dedupe(got, 1e-6), dedupe(test.want, 1e-6), 1e-6) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// A ray running close to the axis of a cylinder that's been scaled up by a
+// transform has a tiny direction in the cylinder's space, which mustn't be
+// mistaken for a ray running exactly along the axis
+func TestCylinderNearAxisUnderScale(t *testing.T) {
+	transform, _ := NewTransform(ScaleMatrix(Vec3{1000, 1000, 1000}))
+	cylinder := NewTransformed(NewCylinder(Vec3{0, 0, 0}, Vec3{0, 1, 0}, 1, 1e6, nil), transform)
+
+	// The ray starts on the axis and leaves through the side
+	r := Ray{Vec3{0, 1, 0}, Vec3{0.01, 1, 0}}
+	rec, ok := cylinder.Hit(r, Interval{1e-9, math.Inf(1)})
+	if !ok {
+		t.Fatal("missed the side")
+	}
+	if want := 1e5; math.Abs(rec.t-want) > 1e-6*want {
+		t.Errorf("hit at t = %v, want %v", rec.t, want)
+	}
+}
+
+// The roots with any repeats closer than tolerance removed
+func dedupe(roots []float64, tolerance float64) []float64 {
+	var unique []float64
+	for _, root := range roots {
+		if len(unique) == 0 || math.Abs(root-unique[len(unique)-1]) > tolerance {
+			unique = append(unique, root)
+		}
+	}
+
+	return unique
+}
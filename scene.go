@@ -0,0 +1,1559 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// The newest version of the scene file format this loader understands
+const sceneVersion = 2
+
+// The kinds of material a scene can use
+var materialTypes = []string{"lambertian", "metal", "dielectric"}
+
+// The kinds of texture a scene can use
+var textureTypes = []string{"solid", "checker", "image", "turbulence", "marble"}
+
+// A scene as described by a JSON scene file
+type SceneFile struct {
+	Version    int                     `json:"version"`
+	Camera     *CameraSpec             `json:"camera"`
+	Sky        *SkySpec                `json:"sky"`
+	Render     RenderSpec              `json:"render"`
+	Textures   map[string]TextureSpec  `json:"textures"`
+	Materials  map[string]MaterialSpec `json:"materials"`
+	Prototypes map[string]ObjectSpec   `json:"prototypes"`
+	Objects    []ObjectSpec            `json:"objects"`
+
+	textureNames   []string           // In file order, so textures are built after the ones they use
+	textures       map[string]Texture // Built while the scene is checked
+	prototypeNames []string           // In file order, so prototypes are built after the ones they use
+}
+
+// A camera looking from one point at another, with a vertical field of view
+// in degrees and an optional up direction that defaults to +Y
+// Depth of field comes from a defocus angle in degrees, a focus distance that
+// defaults to the distance to lookAt and an optional number of aperture blades
+// Version 1 files describe a camera looking down -Z with a position, focal
+// length and viewport height instead, and are converted when loaded
+type CameraSpec struct {
+	LookFrom [3]float64 `json:"lookFrom"`
+	LookAt   [3]float64 `json:"lookAt"`
+	Up       [3]float64 `json:"up"`
+	VFOV     float64    `json:"vfov"`
+
+	DefocusAngle   float64 `json:"defocusAngle"`
+	FocusDistance  float64 `json:"focusDistance"`
+	ApertureBlades int     `json:"apertureBlades"`
+
+	Position       [3]float64 `json:"position"`
+	FocalLength    float64    `json:"focalLength"`
+	ViewportHeight float64    `json:"viewportHeight"`
+}
+
+// The sky is a "gradient" from the horizon color to the zenith color by
+// default, "black" so the scene is only lit by its own lights, or an
+// "environment" map from an equirectangular image file, found relative to the
+// scene file and turned by a rotation in degrees about +Y, or a "physical" sky
+// with the sun at an elevation (45 by default) and azimuth in degrees through
+// air with a turbidity from 2 to 10 (3 by default). Environment and physical
+// skies are scaled by an intensity that defaults to 1
+type SkySpec struct {
+	Type      string    `json:"type"`
+	Horizon   *[3]uint8 `json:"horizon"`
+	Zenith    *[3]uint8 `json:"zenith"`
+	File      string    `json:"file"`
+	Rotation  float64   `json:"rotation"`
+	Elevation *float64  `json:"elevation"`
+	Azimuth   float64   `json:"azimuth"`
+	Turbidity float64   `json:"turbidity"`
+	Intensity *float64  `json:"intensity"`
+
+	environment *Environment // Loaded while the scene is checked
+	physical    *PhysicalSky // Built while the scene is checked
+}
+
+// Render settings; zero values leave the current settings alone
+type RenderSpec struct {
+	Width           int     `json:"width"`
+	Height          int     `json:"height"`
+	SamplesPerPixel int     `json:"samplesPerPixel"`
+	MaxBounces      int     `json:"maxBounces"`
+	ToneMap         string  `json:"toneMap"`
+	Exposure        float64 `json:"exposure"`
+	WhitePoint      float64 `json:"whitePoint"`
+}
+
+// A texture; which fields are used depends on its type
+//   - solid: a color
+//   - checker: cubes of the even and odd colors or textures, scale units wide
+//   - image: a PNG or JPEG file found relative to the scene file, which wraps
+//     by repeating, clamping or mirroring. linear images hold data such as
+//     roughness rather than colors
+//   - turbulence and marble: Perlin noise shading a color, with scale features
+//     per unit, a number of octaves and a seed
+type TextureSpec struct {
+	Type    string    `json:"type"`
+	Color   *[3]uint8 `json:"color"`
+	Even    ColorRef  `json:"even"`
+	Odd     ColorRef  `json:"odd"`
+	Scale   float64   `json:"scale"`
+	File    string    `json:"file"`
+	Wrap    string    `json:"wrap"`
+	Linear  bool      `json:"linear"`
+	Octaves int       `json:"octaves"`
+	Seed    uint64    `json:"seed"`
+
+	image *ImageTexture // Loaded while the scene is checked
+}
+
+// A color given either as sRGB [r, g, b] or by the name of a texture
+type ColorRef struct {
+	rgb     [3]uint8
+	texture string
+}
+
+func (c *ColorRef) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.texture); err == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &c.rgb); err != nil {
+		return fmt.Errorf("expected an [r, g, b] color or a texture name, got %s", data)
+	}
+
+	return nil
+}
+
+// A number given either directly or by the name of a texture, whose
+// brightness is used
+type ScalarRef struct {
+	value   float64
+	texture string
+}
+
+func (s *ScalarRef) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &s.texture); err == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &s.value); err != nil {
+		return fmt.Errorf("expected a number or a texture name, got %s", data)
+	}
+
+	return nil
+}
+
+// A material; emissive materials give off light of the emission color, scaled
+// by the emission intensity, which defaults to 1. Without a type, transparent
+// materials are dielectrics, fully rough ones are lambertian and the rest are
+// metals
+type MaterialSpec struct {
+	Type              string    `json:"type"`
+	Color             ColorRef  `json:"color"`
+	Roughness         ScalarRef `json:"roughness"`
+	Transparency      float64   `json:"transparency"`
+	RefractionIndex   float64   `json:"refractionIndex"`
+	Emission          [3]uint8  `json:"emission"`
+	EmissionIntensity float64   `json:"emissionIntensity"`
+	Thin              bool      `json:"thin"`
+	Anisotropy        float64   `json:"anisotropy"`
+	Conductor         string    `json:"conductor"`
+	NormalMap         string    `json:"normalMap"`
+	Bump              string    `json:"bump"`
+	BumpHeight        float64   `json:"bumpHeight"`
+}
+
+// An object in the scene; which fields are used depends on its type
+//   - sphere: position and radius
+//   - plane: a position on the plane and the normal it faces along
+//   - quad: a corner position and the two edges leading from it, facing
+//     along the cross product of the edges
+//   - disk: a center position, the normal it faces along and a radius
+//   - box: the min and max corners of an axis-aligned box
+//   - cylinder: a position at the center of its base, an axis (up by
+//     default), a radius and a height
+//   - cone: the same as a cylinder, with a topRadius that is 0 for a point
+//   - capsule: a position at the center of its bottom hemisphere, an axis, a
+//     radius and the height between the centers of its hemispheres
+//   - torus: a center position, an axis, the radius of the circle through
+//     the middle of the tube and the tube's tubeRadius
+//   - triangle: three vertices
+//   - mesh: vertices and faces of three vertex indices each, with optional
+//     per-vertex normals and uvs, or smooth to compute the normals
+//   - obj: a Wavefront OBJ file, relative to the scene file, optionally with
+//     only some of its groups; material is only used for faces without one
+//   - instance: a copy of a named prototype, sharing its geometry and
+//     materials
+//   - union, intersection, difference: two or more solid objects combined in
+//     order, so a difference is the first object with the rest cut out of it
+//   - sdf: a signed distance function moved to position, drawn by sphere
+//     tracing it for up to maxSteps steps until within epsilon of the surface,
+//     and optionally cut down to the box between min and max
+//
+// Any object can be moved, turned and resized by a transform
+type ObjectSpec struct {
+	Type     string     `json:"type"`
+	Material string     `json:"material"`
+	Position [3]float64 `json:"position"`
+	Radius   float64    `json:"radius"`
+
+	Normal [3]float64    `json:"normal"`
+	Edges  [2][3]float64 `json:"edges"`
+	Min    [3]float64    `json:"min"`
+	Max    [3]float64    `json:"max"`
+
+	Axis       [3]float64 `json:"axis"`
+	Height     float64    `json:"height"`
+	TopRadius  float64    `json:"topRadius"`
+	TubeRadius float64    `json:"tubeRadius"`
+
+	Prototype string         `json:"prototype"`
+	Transform *TransformSpec `json:"transform"`
+	Objects   []ObjectSpec   `json:"objects"`
+
+	SDF      *SDFSpec `json:"sdf"`
+	MaxSteps int      `json:"maxSteps"`
+	Epsilon  float64  `json:"epsilon"`
+
+	Vertices [][3]float64 `json:"vertices"`
+	Normals  [][3]float64 `json:"normals"`
+	UVs      [][2]float64 `json:"uvs"`
+	Faces    [][3]int     `json:"faces"`
+	Smooth   bool         `json:"smooth"`
+
+	File   string   `json:"file"`
+	Groups []string `json:"groups"`
+
+	// Meshes from files are loaded while the scene is checked, so that any
+	// problems with the files are reported alongside the scene's
+	mesh *Mesh
+}
+
+// A signed distance function for an sdf object, built up from a tree of shapes
+//   - sphere: a radius
+//   - box: its size along each axis
+//   - torus: a ring around the Y axis, with a tube of tubeRadius running
+//     radius from the center
+//   - cylinder: a radius and a height along the Y axis
+//   - mandelbulb: the fractal, with a power (8 by default) and a number of
+//     iterations (10 by default)
+//   - union: two or more shapes, blended together where they're within
+//     smoothness of each other
+//   - repeat: a shape repeated forever, every period units along each axis
+//     where the period isn't 0
+//   - twist: a shape twisted about the Y axis by angle degrees for every unit
+//     up it
+//   - round: a shape grown by radius, rounding off its edges
+//
+// Shapes are centered on the origin, and any of them can be moved to a position
+type SDFSpec struct {
+	Type       string     `json:"type"`
+	Position   [3]float64 `json:"position"`
+	Radius     float64    `json:"radius"`
+	Size       [3]float64 `json:"size"`
+	TubeRadius float64    `json:"tubeRadius"`
+	Height     float64    `json:"height"`
+	Power      float64    `json:"power"`
+	Iterations int        `json:"iterations"`
+	Smoothness float64    `json:"smoothness"`
+	Period     [3]float64 `json:"period"`
+	Angle      float64    `json:"angle"`
+	Shape      *SDFSpec   `json:"shape"`
+	Shapes     []SDFSpec  `json:"shapes"`
+}
+
+// Moves, turns and resizes an object. The object is scaled first, then rotated
+// by the angles in degrees about X, Y and Z in that order, then translated
+type TransformSpec struct {
+	Translate [3]float64  `json:"translate"`
+	Rotate    [3]float64  `json:"rotate"`
+	Scale     *[3]float64 `json:"scale"`
+}
+
+// An error in a scene file, pointing at the line and field responsible
+type SceneError struct {
+	Path  string
+	Line  int
+	Field string
+	Err   error
+}
+
+func (e *SceneError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s:%d: %v", e.Path, e.Line, e.Err)
+	}
+
+	return fmt.Sprintf("%s:%d: %s: %v", e.Path, e.Line, e.Field, e.Err)
+}
+
+func (e *SceneError) Unwrap() error {
+	return e.Err
+}
+
+// Tracks where in the file each part of the scene came from so errors can
+// point back at it
+type sceneDecoder struct {
+	path    string
+	data    []byte
+	decoder *json.Decoder
+}
+
+// The line number of a byte offset in the file
+func (d *sceneDecoder) lineAt(offset int64) int {
+	if offset > int64(len(d.data)) {
+		offset = int64(len(d.data))
+	}
+
+	return bytes.Count(d.data[:offset], []byte("\n")) + 1
+}
+
+// The offset of the next value, skipping the whitespace and separators that
+// the decoder hasn't consumed yet
+func (d *sceneDecoder) nextOffset() int64 {
+	offset := d.decoder.InputOffset()
+	for offset < int64(len(d.data)) {
+		switch d.data[offset] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+			offset++
+			continue
+		}
+		break
+	}
+
+	return offset
+}
+
+// Wrap an error with the line it happened on, preferring the exact offset
+// reported by the JSON decoder when there is one
+func (d *sceneDecoder) errorAt(offset int64, field string, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	if errors.As(err, &syntaxErr) {
+		offset = syntaxErr.Offset
+		if offset >= int64(len(d.data)) {
+			field = ""
+			err = errors.New("unexpected end of file")
+		}
+	} else if errors.As(err, &typeErr) {
+		// The decoder counts from the start of the value it was decoding
+		offset += typeErr.Offset
+		if typeErr.Field != "" {
+			field += "." + typeErr.Field
+		}
+		err = fmt.Errorf("expected %v, got JSON %s", typeErr.Type, typeErr.Value)
+	} else if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		offset = int64(len(d.data))
+		err = errors.New("unexpected end of file")
+	} else if msg, ok := strings.CutPrefix(err.Error(), "json: "); ok {
+		// Unknown fields are only named, so find where the value uses the name
+		// as a key
+		if name, ok := strings.CutPrefix(msg, "unknown field "); ok {
+			offset = d.keyOffset(offset, name)
+		}
+		err = errors.New(msg)
+	}
+
+	return &SceneError{Path: d.path, Line: d.lineAt(offset), Field: field, Err: err}
+}
+
+// The offset of the first use of the quoted name as a key at or after the
+// offset, or the offset itself if there isn't one
+func (d *sceneDecoder) keyOffset(offset int64, quoted string) int64 {
+	for start := offset; start < int64(len(d.data)); {
+		i := bytes.Index(d.data[start:], []byte(quoted))
+		if i < 0 {
+			break
+		}
+
+		found := start + int64(i)
+		rest := bytes.TrimLeft(d.data[found+int64(len(quoted)):], " \t\r\n")
+		if len(rest) > 0 && rest[0] == ':' {
+			return found
+		}
+		start = found + 1
+	}
+
+	return offset
+}
+
+// Read the next token, which must be the given delimiter
+func (d *sceneDecoder) expectDelim(field string, delim json.Delim) error {
+	offset := d.nextOffset()
+	token, err := d.decoder.Token()
+	if err != nil {
+		return d.errorAt(offset, field, err)
+	}
+
+	if token != delim {
+		return d.errorAt(offset, field, fmt.Errorf("expected %q, got %v", delim.String(), token))
+	}
+
+	return nil
+}
+
+// Read the next object key
+func (d *sceneDecoder) key(field string) (string, int64, error) {
+	offset := d.nextOffset()
+	token, err := d.decoder.Token()
+	if err != nil {
+		return "", offset, d.errorAt(offset, field, err)
+	}
+
+	// The decoder guarantees object keys are strings
+	return token.(string), offset, nil
+}
+
+// Decode the next value into v
+func (d *sceneDecoder) value(field string, v any) (int64, error) {
+	offset := d.nextOffset()
+	if err := d.decoder.Decode(v); err != nil {
+		return offset, d.errorAt(offset, field, err)
+	}
+
+	return offset, nil
+}
+
+// Load a scene file, reporting the first problem found in it
+func loadScene(path string) (SceneFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return SceneFile{}, err
+	}
+
+	return parseScene(path, data)
+}
+
+// Parse and validate the contents of a scene file
+func parseScene(path string, data []byte) (SceneFile, error) {
+	d := &sceneDecoder{path: path, data: data, decoder: json.NewDecoder(bytes.NewReader(data))}
+	d.decoder.DisallowUnknownFields()
+
+	scene := SceneFile{Textures: map[string]TextureSpec{}, Materials: map[string]MaterialSpec{}, Prototypes: map[string]ObjectSpec{}}
+
+	// Remember where things were so validation errors can point at them
+	fieldLines := map[string]int{}
+	textureLines := map[string]int{}
+	materialLines := map[string]int{}
+	materialNames := []string{} // In file order, so the first error is reported first
+	prototypeLines := map[string]int{}
+	objectLines := []int{}
+
+	if err := d.expectDelim("", json.Delim('{')); err != nil {
+		return scene, err
+	}
+
+	for d.decoder.More() {
+		key, keyOffset, err := d.key("")
+		if err != nil {
+			return scene, err
+		}
+
+		if _, seen := fieldLines[key]; seen {
+			return scene, d.errorAt(keyOffset, key, errors.New("duplicate field"))
+		}
+		fieldLines[key] = d.lineAt(keyOffset)
+
+		switch key {
+		case "version":
+			_, err = d.value(key, &scene.Version)
+		case "camera":
+			_, err = d.value(key, &scene.Camera)
+		case "sky":
+			_, err = d.value(key, &scene.Sky)
+		case "render":
+			_, err = d.value(key, &scene.Render)
+
+		case "textures":
+			if err = d.expectDelim(key, json.Delim('{')); err != nil {
+				return scene, err
+			}
+
+			for d.decoder.More() {
+				name, nameOffset, err := d.key(key)
+				if err != nil {
+					return scene, err
+				}
+
+				field := fmt.Sprintf("textures.%s", name)
+				if _, seen := textureLines[name]; seen {
+					return scene, d.errorAt(nameOffset, field, errors.New("duplicate texture"))
+				}
+
+				var texture TextureSpec
+				if _, err = d.value(field, &texture); err != nil {
+					return scene, err
+				}
+
+				scene.Textures[name] = texture
+				textureLines[name] = d.lineAt(nameOffset)
+				scene.textureNames = append(scene.textureNames, name)
+			}
+
+			err = d.expectDelim(key, json.Delim('}'))
+
+		case "materials":
+			if err = d.expectDelim(key, json.Delim('{')); err != nil {
+				return scene, err
+			}
+
+			for d.decoder.More() {
+				name, nameOffset, err := d.key(key)
+				if err != nil {
+					return scene, err
+				}
+
+				field := fmt.Sprintf("materials.%s", name)
+				if _, seen := materialLines[name]; seen {
+					return scene, d.errorAt(nameOffset, field, errors.New("duplicate material"))
+				}
+
+				var material MaterialSpec
+				if _, err = d.value(field, &material); err != nil {
+					return scene, err
+				}
+
+				scene.Materials[name] = material
+				materialLines[name] = d.lineAt(nameOffset)
+				materialNames = append(materialNames, name)
+			}
+
+			err = d.expectDelim(key, json.Delim('}'))
+
+		case "prototypes":
+			if err = d.expectDelim(key, json.Delim('{')); err != nil {
+				return scene, err
+			}
+
+			for d.decoder.More() {
+				name, nameOffset, err := d.key(key)
+				if err != nil {
+					return scene, err
+				}
+
+				field := fmt.Sprintf("prototypes.%s", name)
+				if _, seen := prototypeLines[name]; seen {
+					return scene, d.errorAt(nameOffset, field, errors.New("duplicate prototype"))
+				}
+
+				var prototype ObjectSpec
+				if _, err = d.value(field, &prototype); err != nil {
+					return scene, err
+				}
+
+				scene.Prototypes[name] = prototype
+				prototypeLines[name] = d.lineAt(nameOffset)
+				scene.prototypeNames = append(scene.prototypeNames, name)
+			}
+
+			err = d.expectDelim(key, json.Delim('}'))
+
+		case "objects":
+			if err = d.expectDelim(key, json.Delim('[')); err != nil {
+				return scene, err
+			}
+
+			for i := 0; d.decoder.More(); i++ {
+				var object ObjectSpec
+				offset, err := d.value(fmt.Sprintf("objects[%d]", i), &object)
+				if err != nil {
+					return scene, err
+				}
+
+				scene.Objects = append(scene.Objects, object)
+				objectLines = append(objectLines, d.lineAt(offset))
+			}
+
+			err = d.expectDelim(key, json.Delim(']'))
+
+		default:
+			err = d.errorAt(keyOffset, key, errors.New("unknown field"))
+		}
+
+		if err != nil {
+			return scene, err
+		}
+	}
+
+	if err := d.expectDelim("", json.Delim('}')); err != nil {
+		return scene, err
+	}
+
+	// Only whitespace may follow the scene
+	if offset := d.nextOffset(); offset < int64(len(data)) {
+		return scene, d.errorAt(offset, "", errors.New("unexpected data after the scene"))
+	}
+
+	// Check the values make sense now that everything has been read
+	invalid := func(line int, field string, format string, args ...any) error {
+		return &SceneError{Path: path, Line: line, Field: field, Err: fmt.Errorf(format, args...)}
+	}
+
+	if _, ok := fieldLines["version"]; !ok {
+		return scene, invalid(1, "version", "missing (the current version is %d)", sceneVersion)
+	}
+	if scene.Version < 1 || scene.Version > sceneVersion {
+		return scene, invalid(fieldLines["version"], "version", "unsupported version %d (the newest supported is %d)", scene.Version, sceneVersion)
+	}
+
+	if scene.Camera != nil {
+		line := fieldLines["camera"]
+		camera := scene.Camera
+
+		if scene.Version == 1 {
+			if camera.LookFrom != [3]float64{} || camera.LookAt != [3]float64{} || camera.Up != [3]float64{} || camera.VFOV != 0 {
+				return scene, invalid(line, "camera", "lookFrom, lookAt, up and vfov need version 2 or newer")
+			}
+			if camera.FocalLength <= 0 {
+				return scene, invalid(line, "camera.focalLength", "must be positive, got %v", camera.FocalLength)
+			}
+			if camera.ViewportHeight <= 0 {
+				return scene, invalid(line, "camera.viewportHeight", "must be positive, got %v", camera.ViewportHeight)
+			}
+
+			// Aim the same camera with the newer fields
+			camera.LookFrom = camera.Position
+			camera.LookAt = [3]float64{camera.Position[0], camera.Position[1], camera.Position[2] - camera.FocalLength}
+			camera.VFOV = 2 * math.Atan(camera.ViewportHeight/2/camera.FocalLength) * 180 / math.Pi
+		} else {
+			if camera.Position != [3]float64{} || camera.FocalLength != 0 || camera.ViewportHeight != 0 {
+				return scene, invalid(line, "camera", "position, focalLength and viewportHeight were replaced by lookFrom, lookAt and vfov in version 2")
+			}
+			if camera.VFOV <= 0 || camera.VFOV >= 180 {
+				return scene, invalid(line, "camera.vfov", "must be between 0 and 180 degrees, got %v", camera.VFOV)
+			}
+		}
+
+		if camera.DefocusAngle < 0 || camera.DefocusAngle >= 180 {
+			return scene, invalid(line, "camera.defocusAngle", "must be between 0 and 180 degrees, got %v", camera.DefocusAngle)
+		}
+		if camera.FocusDistance < 0 {
+			return scene, invalid(line, "camera.focusDistance", "must not be negative, got %v", camera.FocusDistance)
+		}
+		if camera.ApertureBlades < 0 || camera.ApertureBlades == 1 || camera.ApertureBlades == 2 {
+			return scene, invalid(line, "camera.apertureBlades", "must be 0 for a round aperture or at least 3, got %d", camera.ApertureBlades)
+		}
+
+		if camera.Up == [3]float64{} {
+			camera.Up = [3]float64{0, 1, 0}
+		}
+
+		view := vec3From(camera.LookAt).Sub(vec3From(camera.LookFrom))
+		if view.LengthSquared() == 0 {
+			return scene, invalid(line, "camera.lookAt", "must be a different point to lookFrom")
+		}
+		if view.Cross(vec3From(camera.Up)).LengthSquared() == 0 {
+			return scene, invalid(line, "camera.up", "must not be parallel to the view direction")
+		}
+	}
+
+	if scene.Sky != nil {
+		line := fieldLines["sky"]
+		sky := scene.Sky
+
+		if sky.Type != "" && !slices.Contains(skyTypes, sky.Type) {
+			return scene, invalid(line, "sky.type", "unknown sky %q (choose from %s)", sky.Type, strings.Join(skyTypes, ", "))
+		}
+		if sky.Intensity != nil && *sky.Intensity < 0 {
+			return scene, invalid(line, "sky.intensity", "must not be negative, got %v", *sky.Intensity)
+		}
+		if sky.Elevation != nil && (*sky.Elevation < 0 || *sky.Elevation > 90) {
+			return scene, invalid(line, "sky.elevation", "must be between 0 and 90, got %v", *sky.Elevation)
+		}
+		if sky.Turbidity != 0 && (sky.Turbidity < 2 || sky.Turbidity > 10) {
+			return scene, invalid(line, "sky.turbidity", "must be between 2 and 10, got %v", sky.Turbidity)
+		}
+
+		intensity := 1.0
+		if sky.Intensity != nil {
+			intensity = *sky.Intensity
+		}
+
+		switch sky.Type {
+		case "environment":
+			if sky.File == "" {
+				return scene, invalid(line, "sky.file", "missing")
+			}
+
+			image, err := loadImageTexture(relativeTo(path, sky.File), false, repeatWrap)
+			if err != nil {
+				return scene, invalid(line, "sky.file", "%v", err)
+			}
+
+			sky.environment = NewEnvironment(image, degreesToRadians(sky.Rotation), intensity)
+		case "physical":
+			elevation := 45.0
+			if sky.Elevation != nil {
+				elevation = *sky.Elevation
+			}
+			turbidity := 3.0
+			if sky.Turbidity != 0 {
+				turbidity = sky.Turbidity
+			}
+
+			sky.physical = NewPhysicalSky(degreesToRadians(elevation), degreesToRadians(sky.Azimuth), turbidity, intensity)
+		}
+	}
+
+	if line, ok := fieldLines["render"]; ok {
+		render := scene.Render
+		if render.Width < 0 || render.Height < 0 {
+			return scene, invalid(line, "render", "image size must not be negative, got %dx%d", render.Width, render.Height)
+		}
+		if render.SamplesPerPixel < 0 {
+			return scene, invalid(line, "render.samplesPerPixel", "must not be negative, got %d", render.SamplesPerPixel)
+		}
+		if render.MaxBounces < 0 {
+			return scene, invalid(line, "render.maxBounces", "must not be negative, got %d", render.MaxBounces)
+		}
+		if render.ToneMap != "" {
+			if err := checkToneMap(render.ToneMap); err != nil {
+				return scene, invalid(line, "render.toneMap", "%v", err)
+			}
+		}
+		if render.WhitePoint < 0 {
+			return scene, invalid(line, "render.whitePoint", "must be positive, got %v", render.WhitePoint)
+		}
+	}
+
+	// Textures can only use textures that come before them, so they can't
+	// depend on each other in a loop
+	scene.textures = map[string]Texture{}
+	for _, name := range scene.textureNames {
+		texture := scene.Textures[name]
+		line := textureLines[name]
+		field := "textures." + name
+
+		checkRef := func(ref ColorRef, part string) error {
+			if ref.texture != "" && scene.textures[ref.texture] == nil {
+				return invalid(line, field+"."+part, "unknown texture %q (textures can only use ones defined before them)", ref.texture)
+			}
+			return nil
+		}
+
+		switch texture.Type {
+		case "solid":
+			if texture.Color == nil {
+				return scene, invalid(line, field+".color", "missing")
+			}
+
+		case "checker":
+			if texture.Scale < 0 {
+				return scene, invalid(line, field+".scale", "must not be negative, got %v", texture.Scale)
+			}
+			if err := checkRef(texture.Even, "even"); err != nil {
+				return scene, err
+			}
+			if err := checkRef(texture.Odd, "odd"); err != nil {
+				return scene, err
+			}
+
+		case "image":
+			if texture.File == "" {
+				return scene, invalid(line, field+".file", "missing")
+			}
+			if texture.Wrap == "" {
+				texture.Wrap = "repeat"
+			}
+			wrap, ok := wrapModes[texture.Wrap]
+			if !ok {
+				return scene, invalid(line, field+".wrap", "unknown wrap mode %q (choose from clamp, mirror, repeat)", texture.Wrap)
+			}
+
+			image, err := loadImageTexture(relativeTo(path, texture.File), texture.Linear, wrap)
+			if err != nil {
+				return scene, invalid(line, field+".file", "%v", err)
+			}
+			texture.image = image
+
+		case "turbulence", "marble":
+			if texture.Scale < 0 {
+				return scene, invalid(line, field+".scale", "must not be negative, got %v", texture.Scale)
+			}
+			if texture.Octaves < 0 {
+				return scene, invalid(line, field+".octaves", "must not be negative, got %d", texture.Octaves)
+			}
+
+		default:
+			return scene, invalid(line, field+".type", "unknown texture type %q (choose from %s)", texture.Type, strings.Join(textureTypes, ", "))
+		}
+
+		scene.Textures[name] = texture
+		scene.textures[name] = texture.Texture(scene.textures)
+	}
+
+	for _, name := range materialNames {
+		material := scene.Materials[name]
+		line := materialLines[name]
+		field := "materials." + name
+
+		if material.Type != "" && !slices.Contains(materialTypes, material.Type) {
+			return scene, invalid(line, field+".type", "unknown material type %q (choose from %s)", material.Type, strings.Join(materialTypes, ", "))
+		}
+		if material.Color.texture != "" && scene.textures[material.Color.texture] == nil {
+			return scene, invalid(line, field+".color", "unknown texture %q", material.Color.texture)
+		}
+		if material.Roughness.texture != "" && scene.textures[material.Roughness.texture] == nil {
+			return scene, invalid(line, field+".roughness", "unknown texture %q", material.Roughness.texture)
+		}
+		if material.NormalMap != "" && scene.textures[material.NormalMap] == nil {
+			return scene, invalid(line, field+".normalMap", "unknown texture %q", material.NormalMap)
+		}
+		if material.Bump != "" && scene.textures[material.Bump] == nil {
+			return scene, invalid(line, field+".bump", "unknown texture %q", material.Bump)
+		}
+		if material.NormalMap != "" && material.Bump != "" {
+			return scene, invalid(line, field+".bump", "can't be used together with a normal map")
+		}
+		if material.BumpHeight < 0 {
+			return scene, invalid(line, field+".bumpHeight", "must not be negative, got %v", material.BumpHeight)
+		}
+		if material.Roughness.value < 0 || material.Roughness.value > 1 {
+			return scene, invalid(line, field+".roughness", "must be between 0 and 1, got %v", material.Roughness.value)
+		}
+		if material.Anisotropy < 0 || material.Anisotropy > 1 {
+			return scene, invalid(line, field+".anisotropy", "must be between 0 and 1, got %v", material.Anisotropy)
+		}
+		if _, ok := conductors[material.Conductor]; material.Conductor != "" && !ok {
+			return scene, invalid(line, field+".conductor", "unknown conductor %q (choose from %s)", material.Conductor, conductorNames())
+		}
+		if material.Conductor != "" && material.kind() != "metal" {
+			return scene, invalid(line, field+".conductor", "only metals can be made of a conductor")
+		}
+		if material.Transparency < 0 || material.Transparency > 1 {
+			return scene, invalid(line, field+".transparency", "must be between 0 and 1, got %v", material.Transparency)
+		}
+		if material.kind() == "dielectric" && material.RefractionIndex <= 0 {
+			return scene, invalid(line, field+".refractionIndex", "must be positive for a transparent material, got %v", material.RefractionIndex)
+		}
+		if material.EmissionIntensity < 0 {
+			return scene, invalid(line, field+".emissionIntensity", "must not be negative, got %v", material.EmissionIntensity)
+		}
+	}
+
+	var checkSDF func(shape *SDFSpec, line int, field string) error
+	checkSDF = func(shape *SDFSpec, line int, field string) error {
+		switch shape.Type {
+		case "sphere":
+			if shape.Radius <= 0 {
+				return invalid(line, field+".radius", "must be positive, got %v", shape.Radius)
+			}
+
+		case "box":
+			for axis, name := range []string{"x", "y", "z"} {
+				if shape.Size[axis] <= 0 {
+					return invalid(line, field+".size", "must be positive along %s, got %v", name, shape.Size[axis])
+				}
+			}
+
+		case "torus":
+			if shape.Radius <= 0 {
+				return invalid(line, field+".radius", "must be positive, got %v", shape.Radius)
+			}
+			if shape.TubeRadius <= 0 {
+				return invalid(line, field+".tubeRadius", "must be positive, got %v", shape.TubeRadius)
+			}
+
+		case "cylinder":
+			if shape.Radius <= 0 {
+				return invalid(line, field+".radius", "must be positive, got %v", shape.Radius)
+			}
+			if shape.Height <= 0 {
+				return invalid(line, field+".height", "must be positive, got %v", shape.Height)
+			}
+
+		case "mandelbulb":
+			if shape.Power != 0 && shape.Power < 2 {
+				return invalid(line, field+".power", "must be at least 2, got %v", shape.Power)
+			}
+			if shape.Iterations < 0 {
+				return invalid(line, field+".iterations", "must not be negative, got %d", shape.Iterations)
+			}
+
+		case "union":
+			if len(shape.Shapes) < 2 {
+				return invalid(line, field+".shapes", "needs at least two shapes to blend, got %d", len(shape.Shapes))
+			}
+			if shape.Smoothness < 0 {
+				return invalid(line, field+".smoothness", "must not be negative, got %v", shape.Smoothness)
+			}
+
+			for j := range shape.Shapes {
+				if err := checkSDF(&shape.Shapes[j], line, fmt.Sprintf("%s.shapes[%d]", field, j)); err != nil {
+					return err
+				}
+			}
+
+		case "repeat", "twist", "round":
+			if shape.Shape == nil {
+				return invalid(line, field+".shape", "missing")
+			}
+			if shape.Type == "repeat" {
+				if shape.Period == [3]float64{} {
+					return invalid(line, field+".period", "missing")
+				}
+				for axis, name := range []string{"x", "y", "z"} {
+					if shape.Period[axis] < 0 {
+						return invalid(line, field+".period", "must not be negative along %s, got %v", name, shape.Period[axis])
+					}
+				}
+			}
+			if shape.Type == "round" && shape.Radius <= 0 {
+				return invalid(line, field+".radius", "must be positive, got %v", shape.Radius)
+			}
+
+			if err := checkSDF(shape.Shape, line, field+".shape"); err != nil {
+				return err
+			}
+
+		case "":
+			return invalid(line, field+".type", "missing")
+		default:
+			return invalid(line, field+".type", "unknown shape type %q", shape.Type)
+		}
+
+		return nil
+	}
+
+	// Objects and prototypes are checked the same way. Instances can only use
+	// the prototypes checked before them
+	prototypes := map[string]bool{}
+	var checkObject func(object *ObjectSpec, line int, field string) error
+	checkObject = func(object *ObjectSpec, line int, field string) error {
+		switch object.Type {
+		case "sphere":
+			if object.Radius == 0 {
+				return invalid(line, field+".radius", "must not be zero")
+			}
+
+		case "plane":
+			if object.Normal == [3]float64{} {
+				return invalid(line, field+".normal", "missing")
+			}
+
+		case "quad":
+			if vec3From(object.Edges[0]).Cross(vec3From(object.Edges[1])).LengthSquared() == 0 {
+				return invalid(line, field+".edges", "needs two edges that don't lie on a line")
+			}
+
+		case "disk":
+			if object.Normal == [3]float64{} {
+				return invalid(line, field+".normal", "missing")
+			}
+			if object.Radius <= 0 {
+				return invalid(line, field+".radius", "must be positive, got %v", object.Radius)
+			}
+
+		case "cylinder", "cone", "capsule":
+			if object.Radius <= 0 {
+				return invalid(line, field+".radius", "must be positive, got %v", object.Radius)
+			}
+			if object.Height <= 0 {
+				return invalid(line, field+".height", "must be positive, got %v", object.Height)
+			}
+			if object.TopRadius < 0 {
+				return invalid(line, field+".topRadius", "must not be negative, got %v", object.TopRadius)
+			}
+
+		case "torus":
+			if object.Radius <= 0 {
+				return invalid(line, field+".radius", "must be positive, got %v", object.Radius)
+			}
+			if object.TubeRadius <= 0 {
+				return invalid(line, field+".tubeRadius", "must be positive, got %v", object.TubeRadius)
+			}
+
+		case "box":
+			for axis, name := range []string{"x", "y", "z"} {
+				if object.Min[axis] >= object.Max[axis] {
+					return invalid(line, field+".max", "must be greater than min (%v) along %s, got %v", object.Min[axis], name, object.Max[axis])
+				}
+			}
+
+		case "triangle":
+			if len(object.Vertices) != 3 {
+				return invalid(line, field+".vertices", "a triangle needs 3 vertices, got %d", len(object.Vertices))
+			}
+
+			a, b, c := vec3From(object.Vertices[0]), vec3From(object.Vertices[1]), vec3From(object.Vertices[2])
+			if b.Sub(a).Cross(c.Sub(a)).LengthSquared() == 0 {
+				return invalid(line, field+".vertices", "the vertices must not lie on a line")
+			}
+
+		case "mesh":
+			if len(object.Faces) == 0 {
+				return invalid(line, field+".faces", "a mesh needs at least one face")
+			}
+			if len(object.Normals) > 0 && len(object.Normals) != len(object.Vertices) {
+				return invalid(line, field+".normals", "needs one normal per vertex, got %d for %d vertices", len(object.Normals), len(object.Vertices))
+			}
+			if len(object.Normals) > 0 && object.Smooth {
+				return invalid(line, field+".smooth", "can't compute normals for a mesh that already has them")
+			}
+			if len(object.UVs) > 0 && len(object.UVs) != len(object.Vertices) {
+				return invalid(line, field+".uvs", "needs one uv per vertex, got %d for %d vertices", len(object.UVs), len(object.Vertices))
+			}
+
+			for j, face := range object.Faces {
+				for _, v := range face {
+					if v < 0 || v >= len(object.Vertices) {
+						return invalid(line, fmt.Sprintf("%s.faces[%d]", field, j), "vertex index %d is out of range for %d vertices", v, len(object.Vertices))
+					}
+				}
+			}
+
+		case "obj":
+			if object.File == "" {
+				return invalid(line, field+".file", "missing")
+			}
+
+			file := relativeTo(path, object.File)
+
+			obj, err := loadOBJ(file)
+			if err != nil {
+				return invalid(line, field+".file", "%v", err)
+			}
+
+			defaultMaterial := defaultOBJMaterial
+			if object.Material != "" {
+				spec, ok := scene.Materials[object.Material]
+				if !ok {
+					return invalid(line, field+".material", "unknown material %q", object.Material)
+				}
+				defaultMaterial = spec.Material(scene.textures)
+			}
+
+			mesh, err := obj.Mesh(object.Groups, defaultMaterial, object.Smooth)
+			if err != nil {
+				return invalid(line, field+".groups", "%v", err)
+			}
+			object.mesh = mesh
+
+		case "instance":
+			if object.Prototype == "" {
+				return invalid(line, field+".prototype", "missing")
+			}
+			if !prototypes[object.Prototype] {
+				if _, ok := scene.Prototypes[object.Prototype]; ok {
+					return invalid(line, field+".prototype", "prototype %q must be defined before it's used", object.Prototype)
+				}
+				return invalid(line, field+".prototype", "unknown prototype %q", object.Prototype)
+			}
+			if object.Material != "" {
+				return invalid(line, field+".material", "instances use their prototype's materials")
+			}
+
+		case "sdf":
+			if object.SDF == nil {
+				return invalid(line, field+".sdf", "missing")
+			}
+			if err := checkSDF(object.SDF, line, field+".sdf"); err != nil {
+				return err
+			}
+			if object.MaxSteps < 0 {
+				return invalid(line, field+".maxSteps", "must not be negative, got %d", object.MaxSteps)
+			}
+			if object.Epsilon < 0 {
+				return invalid(line, field+".epsilon", "must not be negative, got %v", object.Epsilon)
+			}
+
+			if object.Min == object.Max {
+				if !object.SDF.SDF().box.Bounded() {
+					return invalid(line, field+".max", "the shape goes on forever, so needs min and max to cut it down to size")
+				}
+				break
+			}
+			for axis, name := range []string{"x", "y", "z"} {
+				if object.Min[axis] >= object.Max[axis] {
+					return invalid(line, field+".max", "must be greater than min (%v) along %s, got %v", object.Min[axis], name, object.Max[axis])
+				}
+			}
+
+		case "union", "intersection", "difference":
+			if len(object.Objects) < 2 {
+				return invalid(line, field+".objects", "needs at least two objects to combine, got %d", len(object.Objects))
+			}
+			if object.Material != "" {
+				return invalid(line, field+".material", "combined objects keep their own materials")
+			}
+
+			// The objects are in the combination's part of the file
+			for j := range object.Objects {
+				part := &object.Objects[j]
+				partField := fmt.Sprintf("%s.objects[%d]", field, j)
+				if err := checkObject(part, line, partField); err != nil {
+					return err
+				}
+				if !part.solid(scene.Prototypes) {
+					if part.Type == "instance" {
+						return invalid(line, partField+".prototype", "can't combine prototype %q, which doesn't enclose a space", part.Prototype)
+					}
+					return invalid(line, partField+".type", "can't combine a %s, which doesn't enclose a space", part.Type)
+				}
+			}
+
+		case "":
+			return invalid(line, field+".type", "missing")
+		default:
+			return invalid(line, field+".type", "unknown object type %q", object.Type)
+		}
+
+		if object.Transform != nil && object.Transform.Scale != nil {
+			for axis, name := range []string{"x", "y", "z"} {
+				if object.Transform.Scale[axis] == 0 {
+					return invalid(line, field+".transform.scale", "must not be zero along %s", name)
+				}
+			}
+		}
+
+		// Instances and combinations are made of other objects' materials
+		switch object.Type {
+		case "instance", "union", "intersection", "difference":
+			return nil
+		}
+
+		if _, ok := scene.Materials[object.Material]; !ok && (object.Type != "obj" || object.Material != "") {
+			return invalid(line, field+".material", "unknown material %q", object.Material)
+		}
+
+		return nil
+	}
+
+	for _, name := range scene.prototypeNames {
+		prototype := scene.Prototypes[name]
+		if err := checkObject(&prototype, prototypeLines[name], "prototypes."+name); err != nil {
+			return scene, err
+		}
+
+		scene.Prototypes[name] = prototype
+		prototypes[name] = true
+	}
+
+	for i := range scene.Objects {
+		if err := checkObject(&scene.Objects[i], objectLines[i], fmt.Sprintf("objects[%d]", i)); err != nil {
+			return scene, err
+		}
+	}
+
+	return scene, nil
+}
+
+// Build the texture described by the spec, using the textures built so far
+func (t TextureSpec) Texture(textures map[string]Texture) Texture {
+	scale := t.Scale
+	if scale == 0 {
+		scale = 1
+	}
+
+	color := Vec3{1, 1, 1}
+	if t.Color != nil {
+		color = colorVec3From(*t.Color)
+	}
+
+	octaves := t.Octaves
+	if octaves == 0 {
+		octaves = 7
+	}
+
+	switch t.Type {
+	case "checker":
+		return Checker{scale: scale, even: t.Even.Texture(textures), odd: t.Odd.Texture(textures)}
+	case "image":
+		return t.image
+	case "turbulence":
+		return Turbulence{noise: NewPerlin(t.Seed), scale: scale, octaves: octaves, color: color}
+	case "marble":
+		return Marble{noise: NewPerlin(t.Seed), scale: scale, octaves: octaves, color: color}
+	}
+
+	return SolidColor{color}
+}
+
+// The texture a color refers to, or the color itself
+func (c ColorRef) Texture(textures map[string]Texture) Texture {
+	if c.texture != "" {
+		return textures[c.texture]
+	}
+
+	return SolidColor{colorVec3From(c.rgb)}
+}
+
+// The texture a number refers to, or the number itself
+func (s ScalarRef) Texture(textures map[string]Texture) Texture {
+	if s.texture != "" {
+		return textures[s.texture]
+	}
+
+	return SolidColor{Vec3{s.value, s.value, s.value}}
+}
+
+func (m MaterialSpec) Material(textures map[string]Texture) Material {
+	emission := Emission{
+		emission:          colorVec3From(m.Emission),
+		emissionIntensity: m.EmissionIntensity,
+	}
+
+	if m.Emission != [3]uint8{} && m.EmissionIntensity == 0 {
+		emission.emissionIntensity = 1
+	}
+
+	color := m.Color.Texture(textures)
+	roughness := m.Roughness.Texture(textures)
+
+	detail := Detail{normalMap: textures[m.NormalMap], bump: textures[m.Bump], bumpHeight: m.BumpHeight}
+	if detail.bumpHeight == 0 {
+		detail.bumpHeight = 0.01
+	}
+
+	switch m.kind() {
+	case "dielectric":
+		return Dielectric{
+			Emission:        emission,
+			Detail:          detail,
+			tint:            color,
+			refractionIndex: m.RefractionIndex,
+			thin:            m.Thin,
+			roughness:       roughness,
+			anisotropy:      m.Anisotropy,
+		}
+	case "metal":
+		metal := Metal{Emission: emission, Detail: detail, albedo: color, roughness: roughness, anisotropy: m.Anisotropy}
+		if conductor, ok := conductors[m.Conductor]; ok {
+			metal.conductor = &conductor
+		}
+		return metal
+	}
+
+	return Lambertian{Emission: emission, Detail: detail, albedo: color}
+}
+
+// The type of the material, worked out from its other fields if it isn't given
+func (m MaterialSpec) kind() string {
+	switch {
+	case m.Type != "":
+		return m.Type
+	case m.Conductor != "":
+		return "metal"
+	case m.Transparency > 0:
+		return "dielectric"
+	case m.Roughness.texture == "" && m.Roughness.value >= 1:
+		return "lambertian"
+	}
+
+	return "metal"
+}
+
+// Build the object described by the spec out of the scene's materials and the
+// prototypes built so far
+func (o ObjectSpec) Object(materials map[string]Material, prototypes map[string]Object) Object {
+	object := o.shape(materials, prototypes)
+	if o.Transform == nil {
+		return object
+	}
+
+	transform, ok := NewTransform(o.Transform.Matrix())
+	if !ok {
+		// Unreachable for a validated scene
+		panic("object transform can't be inverted")
+	}
+
+	return NewTransformed(object, transform)
+}
+
+// Build the object before it's transformed
+func (o ObjectSpec) shape(materials map[string]Material, prototypes map[string]Object) Object {
+	switch o.Type {
+	case "sphere":
+		return Sphere{
+			position: vec3From(o.Position),
+			radius:   o.Radius,
+			material: materials[o.Material],
+		}
+
+	case "plane":
+		return NewPlane(vec3From(o.Position), vec3From(o.Normal), materials[o.Material])
+
+	case "quad":
+		return NewQuad(vec3From(o.Position), vec3From(o.Edges[0]), vec3From(o.Edges[1]), materials[o.Material])
+
+	case "disk":
+		return NewDisk(vec3From(o.Position), vec3From(o.Normal), o.Radius, materials[o.Material])
+
+	case "box":
+		return NewBox(vec3From(o.Min), vec3From(o.Max), materials[o.Material])
+
+	case "cylinder":
+		return NewCylinder(vec3From(o.Position), o.axis(), o.Radius, o.Height, materials[o.Material])
+
+	case "cone":
+		return NewCone(vec3From(o.Position), o.axis(), o.Radius, o.TopRadius, o.Height, materials[o.Material])
+
+	case "capsule":
+		return NewCapsule(vec3From(o.Position), o.axis(), o.Radius, o.Height, materials[o.Material])
+
+	case "torus":
+		return NewTorus(vec3From(o.Position), o.axis(), o.Radius, o.TubeRadius, materials[o.Material])
+
+	case "triangle":
+		return NewTriangle(vec3From(o.Vertices[0]), vec3From(o.Vertices[1]), vec3From(o.Vertices[2]), materials[o.Material])
+
+	case "mesh":
+		vertices := make([]Vec3, len(o.Vertices))
+		for i, v := range o.Vertices {
+			vertices[i] = vec3From(v)
+		}
+
+		normals := make([]Vec3, len(o.Normals))
+		for i, n := range o.Normals {
+			normals[i] = vec3From(n).Unit()
+		}
+
+		uvs := make([]Vec3, len(o.UVs))
+		for i, uv := range o.UVs {
+			uvs[i] = Vec3{uv[0], uv[1], 0}
+		}
+
+		// The normals and uvs are per vertex, so they share its indices
+		faces := make([]MeshFace, len(o.Faces))
+		for i, f := range o.Faces {
+			faces[i] = MeshFace{vertices: f, normals: [3]int{-1, -1, -1}, uvs: [3]int{-1, -1, -1}}
+			if len(normals) > 0 {
+				faces[i].normals = f
+			}
+			if len(uvs) > 0 {
+				faces[i].uvs = f
+			}
+		}
+
+		if o.Smooth {
+			normals = computeVertexNormals(vertices, faces)
+		}
+
+		return NewMesh(vertices, normals, uvs, faces, []Material{materials[o.Material]})
+
+	case "obj":
+		return o.mesh
+
+	case "instance":
+		return prototypes[o.Prototype]
+
+	case "sdf":
+		sdf := Moved(o.SDF.SDF(), vec3From(o.Position))
+
+		box := sdf.box
+		if o.Min != o.Max {
+			box = box.Intersect(AABB{min: vec3From(o.Min), max: vec3From(o.Max)})
+		}
+
+		maxSteps := o.MaxSteps
+		if maxSteps == 0 {
+			maxSteps = defaultSDFSteps
+		}
+		epsilon := o.Epsilon
+		if epsilon == 0 {
+			epsilon = defaultSDFEpsilon
+		}
+
+		return NewSDFObject(sdf, box, maxSteps, epsilon, materials[o.Material])
+
+	case "union", "intersection", "difference":
+		object := o.Objects[0].Object(materials, prototypes)
+		for _, spec := range o.Objects[1:] {
+			csg, ok := NewCSG(csgOperations[o.Type], object, spec.Object(materials, prototypes))
+			if !ok {
+				// Unreachable for a validated scene
+				panic("combined objects don't enclose a space")
+			}
+			object = csg
+		}
+		return object
+	}
+
+	// Unreachable for a validated scene
+	panic(fmt.Sprintf("unknown object type %q", o.Type))
+}
+
+// Build the signed distance function described by the spec
+func (s SDFSpec) SDF() SDF {
+	var sdf SDF
+
+	switch s.Type {
+	case "sphere":
+		sdf = SphereSDF(s.Radius)
+
+	case "box":
+		sdf = BoxSDF(vec3From(s.Size))
+
+	case "torus":
+		sdf = TorusSDF(s.Radius, s.TubeRadius)
+
+	case "cylinder":
+		sdf = CylinderSDF(s.Radius, s.Height)
+
+	case "mandelbulb":
+		power := s.Power
+		if power == 0 {
+			power = 8
+		}
+		iterations := s.Iterations
+		if iterations == 0 {
+			iterations = 10
+		}
+		sdf = MandelbulbSDF(power, iterations)
+
+	case "union":
+		sdf = s.Shapes[0].SDF()
+		for _, shape := range s.Shapes[1:] {
+			sdf = SmoothUnion(sdf, shape.SDF(), s.Smoothness)
+		}
+
+	case "repeat":
+		sdf = Repeat(s.Shape.SDF(), vec3From(s.Period))
+
+	case "twist":
+		sdf = Twist(s.Shape.SDF(), degreesToRadians(s.Angle))
+
+	case "round":
+		sdf = Round(s.Shape.SDF(), s.Radius)
+	}
+
+	if s.Position == [3]float64{} {
+		return sdf
+	}
+	return Moved(sdf, vec3From(s.Position))
+}
+
+// Whether the object encloses a space, so that it can be combined with others
+// Meshes are trusted to be closed
+func (o ObjectSpec) solid(prototypes map[string]ObjectSpec) bool {
+	switch o.Type {
+	case "sphere", "plane", "box", "cylinder", "cone", "capsule", "torus", "sdf", "mesh", "obj", "union", "intersection", "difference":
+		return true
+	case "instance":
+		return prototypes[o.Prototype].solid(prototypes)
+	}
+
+	return false
+}
+
+// The matrix that scales, then rotates about X, Y and Z, then translates
+func (t TransformSpec) Matrix() Matrix {
+	scale := Vec3{1, 1, 1}
+	if t.Scale != nil {
+		scale = vec3From(*t.Scale)
+	}
+
+	return TranslationMatrix(vec3From(t.Translate)).
+		Mul(RotationMatrix(Vec3{0, 0, 1}, degreesToRadians(t.Rotate[2]))).
+		Mul(RotationMatrix(Vec3{0, 1, 0}, degreesToRadians(t.Rotate[1]))).
+		Mul(RotationMatrix(Vec3{1, 0, 0}, degreesToRadians(t.Rotate[0]))).
+		Mul(ScaleMatrix(scale))
+}
+
+// The axis the object is built around, pointing up unless it's given
+func (o ObjectSpec) axis() Vec3 {
+	if o.Axis == [3]float64{} {
+		return Vec3{0, 1, 0}
+	}
+
+	return vec3From(o.Axis)
+}
+
+// Find a file named in a scene file, which is relative to the scene file
+// unless it's an absolute path
+func relativeTo(sceneFile string, file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+
+	return filepath.Join(filepath.Dir(sceneFile), file)
+}
+
+func vec3From(a [3]float64) Vec3 {
+	return Vec3{a[0], a[1], a[2]}
+}
+
+// Convert an sRGB color from 0 to 255 into linear RGB from 0 to 1
+func colorVec3From(c [3]uint8) Vec3 {
+	return linearFromRGBA(color.RGBA{c[0], c[1], c[2], maxColorVal})
+}
+
+// Replace the current scene and settings with the ones from a scene file
+// Settings named in skip were given on the command line and take priority
+func applyScene(scene SceneFile, skip map[string]bool) {
+	if scene.Camera != nil {
+		cameraLookFrom = vec3From(scene.Camera.LookFrom)
+		cameraLookAt = vec3From(scene.Camera.LookAt)
+		cameraUp = vec3From(scene.Camera.Up)
+		cameraVFOV = scene.Camera.VFOV
+		cameraDefocusAngle = scene.Camera.DefocusAngle
+		cameraFocusDist = scene.Camera.FocusDistance
+		cameraApertureBlades = scene.Camera.ApertureBlades
+	}
+
+	if scene.Sky != nil {
+		// Colors that aren't given keep the default gradient
+		if scene.Sky.Horizon != nil {
+			white = colorVec3From(*scene.Sky.Horizon)
+		}
+		if scene.Sky.Zenith != nil {
+			sky = colorVec3From(*scene.Sky.Zenith)
+		}
+		environment = scene.Sky.environment
+		if scene.Sky.physical != nil {
+			physicalSky = scene.Sky.physical
+		}
+
+		if !skip["sky"] {
+			skyType = "gradient"
+			if scene.Sky.Type != "" {
+				skyType = scene.Sky.Type
+			}
+		}
+	}
+
+	// Zero values mean the scene doesn't care
+	override := func(dst *int, flagName string, val int) {
+		if val > 0 && !skip[flagName] {
+			*dst = val
+		}
+	}
+	override(&screenWidth, "width", scene.Render.Width)
+	override(&screenHeight, "height", scene.Render.Height)
+	override(&samplesPerPixel, "samples", scene.Render.SamplesPerPixel)
+	override(&maxBounces, "bounces", scene.Render.MaxBounces)
+
+	if scene.Render.ToneMap != "" && !skip["tonemap"] {
+		toneMapName = scene.Render.ToneMap
+	}
+	if scene.Render.Exposure != 0 && !skip["exposure"] {
+		exposure = scene.Render.Exposure
+	}
+	if scene.Render.WhitePoint > 0 && !skip["whitepoint"] {
+		whitePoint = scene.Render.WhitePoint
+	}
+
+	materials := make(map[string]Material, len(scene.Materials))
+	for name, spec := range scene.Materials {
+		materials[name] = spec.Material(scene.textures)
+	}
+
+	// Prototypes are only built once, however many instances use them
+	prototypes := make(map[string]Object, len(scene.Prototypes))
+	for _, name := range scene.prototypeNames {
+		prototypes[name] = scene.Prototypes[name].Object(materials, prototypes)
+	}
+
+	objects = make([]Object, 0, len(scene.Objects))
+	for _, spec := range scene.Objects {
+		objects = append(objects, spec.Object(materials, prototypes))
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseSceneErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		scene string
+		line  int
+		field string
+	}{
+		{
+			name: "malformed JSON",
+			scene: `{
+  "version": 2,
+  "render": { "samplesPerPixel": 4, }
+}`,
+			line:  3,
+			field: "render",
+		},
+		{
+			name: "wrong type",
+			scene: `{
+  "version": 2,
+  "render": {
+    "samplesPerPixel": "lots"
+  }
+}`,
+			line:  4,
+			field: "render.samplesPerPixel",
+		},
+		{
+			name: "unknown field",
+			scene: `{
+  "version": 2,
+  "camera": {
+    "lookFrom": [0, 0, 0],
+    "lookAt": [0, 0, -1],
+    "vfov": 45,
+    "zoom": 2
+  }
+}`,
+			line:  7,
+			field: "camera",
+		},
+		{
+			name: "unknown top level field",
+			scene: `{
+  "version": 2,
+
+  "lighting": {}
+}`,
+			line:  4,
+			field: "lighting",
+		},
+		{
+			name: "duplicate field",
+			scene: `{
+  "version": 2,
+  "version": 2
+}`,
+			line:  3,
+			field: "version",
+		},
+		{
+			name: "out of range camera",
+			scene: `{
+  "version": 2,
+  "camera": {
+    "lookFrom": [0, 0, 0],
+    "lookAt": [0, 0, -1],
+    "vfov": 200
+  }
+}`,
+			line:  3,
+			field: "camera.vfov",
+		},
+		{
+			name: "negative texture scale",
+			scene: `{
+  "version": 2,
+  "textures": {
+    "checks": { "type": "checker", "scale": -1, "even": [0, 0, 0], "odd": [255, 255, 255] }
+  }
+}`,
+			line:  4,
+			field: "textures.checks.scale",
+		},
+		{
+			name: "out of range object",
+			scene: `{
+  "version": 2,
+  "materials": {
+    "matte": { "type": "lambertian", "color": [200, 200, 200] }
+  },
+  "objects": [
+    { "type": "sphere", "position": [0, 0, -1], "radius": 0.5, "material": "matte" },
+    { "type": "cylinder", "position": [0, 0, -1], "radius": 0.5, "height": -1, "material": "matte" }
+  ]
+}`,
+			line:  8,
+			field: "objects[1].height",
+		},
+		{
+			name: "unknown material",
+			scene: `{
+  "version": 2,
+  "objects": [
+    {
+      "type": "sphere",
+      "position": [0, 0, -1],
+      "radius": 0.5,
+      "material": "missing"
+    }
+  ]
+}`,
+			line:  4,
+			field: "objects[0].material",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := parseScene("test.json", []byte(test.scene))
+
+		var sceneErr *SceneError
+		if !errors.As(err, &sceneErr) {
+			t.Errorf("%s: got %v, want a scene error", test.name, err)
+			continue
+		}
+		if sceneErr.Line != test.line || sceneErr.Field != test.field {
+			t.Errorf("%s: got %q at line %d, want %q at line %d (%v)", test.name, sceneErr.Field, sceneErr.Line, test.field, test.line, err)
+		}
+	}
+}
+
+func TestParseSceneAcceptsScenes(t *testing.T) {
+	for _, path := range []string{"scenes/default.json", "scenes/shapes.json", "scenes/csg.json", "scenes/sdf.json", "scenes/instances.json"} {
+		if _, err := loadScene(path); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
+
+// Put back every setting a scene or a render can change once the test is done,
+// so later tests see the defaults
+func keepSettings(t *testing.T) {
+	savedLookFrom, savedLookAt, savedUp := cameraLookFrom, cameraLookAt, cameraUp
+	savedVFOV, savedDefocusAngle, savedFocusDist, savedBlades := cameraVFOV, cameraDefocusAngle, cameraFocusDist, cameraApertureBlades
+	savedWhite, savedSky, savedSkyType := white, sky, skyType
+	savedEnvironment, savedPhysicalSky := environment, physicalSky
+	savedWidth, savedHeight, savedSamples, savedBounces := screenWidth, screenHeight, samplesPerPixel, maxBounces
+	savedToneMap, savedExposure, savedWhitePoint := toneMapName, exposure, whitePoint
+	savedObjects, savedWorld, savedLights, savedWorkers := objects, world, lights, renderWorkers
+
+	t.Cleanup(func() {
+		cameraLookFrom, cameraLookAt, cameraUp = savedLookFrom, savedLookAt, savedUp
+		cameraVFOV, cameraDefocusAngle, cameraFocusDist, cameraApertureBlades = savedVFOV, savedDefocusAngle, savedFocusDist, savedBlades
+		white, sky, skyType = savedWhite, savedSky, savedSkyType
+		environment, physicalSky = savedEnvironment, savedPhysicalSky
+		screenWidth, screenHeight, samplesPerPixel, maxBounces = savedWidth, savedHeight, savedSamples, savedBounces
+		toneMapName, exposure, whitePoint = savedToneMap, savedExposure, savedWhitePoint
+		objects, world, lights, renderWorkers = savedObjects, savedWorld, savedLights, savedWorkers
+	})
+}
+
+func TestApplySceneKeepsDefaultSky(t *testing.T) {
+	keepSettings(t)
+	defaultWhite, defaultSky := white, sky
+
+	tests := []struct {
+		name    string
+		sky     string
+		horizon Vec3
+		zenith  Vec3
+	}{
+		{"empty sky", `{}`, defaultWhite, defaultSky},
+		{"black sky", `{ "type": "black" }`, defaultWhite, defaultSky},
+		{"horizon only", `{ "horizon": [0, 0, 0] }`, Vec3{0, 0, 0}, defaultSky},
+		{"both colors", `{ "horizon": [0, 0, 0], "zenith": [255, 255, 255] }`, Vec3{0, 0, 0}, Vec3{1, 1, 1}},
+	}
+
+	for _, test := range tests {
+		white, sky = defaultWhite, defaultSky
+
+		scene, err := parseScene("test.json", []byte(`{ "version": 2, "sky": `+test.sky+` }`))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		applyScene(scene, map[string]bool{"sky": true})
+
+		if white != test.horizon || sky != test.zenith {
+			t.Errorf("%s: got a gradient from %v to %v, want %v to %v", test.name, white, sky, test.horizon, test.zenith)
+		}
+	}
+}
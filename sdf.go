@@ -0,0 +1,361 @@
+package main
+
+import "math"
+
+// How many steps a ray takes towards a signed distance field before giving up,
+// and how close it has to get to count as a hit, unless the scene says
+// otherwise
+const (
+	defaultSDFSteps   = 256
+	defaultSDFEpsilon = 1e-4
+)
+
+// A signed distance function, giving how far a point is from a surface:
+// positive outside it and negative inside. A distance may be less than the
+// real one, which only slows down sphere tracing, but must never be more, or
+// rays would step right through the surface
+// The box bounds everywhere the distance can be zero or less
+type SDF struct {
+	distance func(p Vec3) float64
+	box      AABB
+}
+
+func (s SDF) Distance(p Vec3) float64 {
+	return s.distance(p)
+}
+
+// A box around the origin going out to extent along every axis
+func originBox(extent Vec3) AABB {
+	return AABB{min: extent.Scale(-1), max: extent}
+}
+
+// A ball of radius around the origin
+func SphereSDF(radius float64) SDF {
+	return SDF{
+		distance: func(p Vec3) float64 {
+			return p.Length() - radius
+		},
+		box: originBox(Vec3{radius, radius, radius}),
+	}
+}
+
+// A box of size centered on the origin
+func BoxSDF(size Vec3) SDF {
+	half := size.Scale(0.5)
+
+	return SDF{
+		distance: func(p Vec3) float64 {
+			q := Vec3{math.Abs(p.x), math.Abs(p.y), math.Abs(p.z)}.Sub(half)
+			outside := q.Max(Vec3{}).Length()
+			inside := math.Min(math.Max(q.x, math.Max(q.y, q.z)), 0)
+			return outside + inside
+		},
+		box: originBox(half),
+	}
+}
+
+// A ring around the Y axis, with a tube of minorRadius running majorRadius
+// from the origin
+func TorusSDF(majorRadius float64, minorRadius float64) SDF {
+	extent := majorRadius + minorRadius
+
+	return SDF{
+		distance: func(p Vec3) float64 {
+			ring := math.Sqrt(p.x*p.x+p.z*p.z) - majorRadius
+			return math.Sqrt(ring*ring+p.y*p.y) - minorRadius
+		},
+		box: originBox(Vec3{extent, minorRadius, extent}),
+	}
+}
+
+// A capped cylinder standing along the Y axis, centered on the origin
+func CylinderSDF(radius float64, height float64) SDF {
+	half := height / 2
+
+	return SDF{
+		distance: func(p Vec3) float64 {
+			side := math.Sqrt(p.x*p.x+p.z*p.z) - radius
+			end := math.Abs(p.y) - half
+			outside := math.Hypot(math.Max(side, 0), math.Max(end, 0))
+			inside := math.Min(math.Max(side, end), 0)
+			return outside + inside
+		},
+		box: originBox(Vec3{radius, half, radius}),
+	}
+}
+
+// The Mandelbulb fractal, the set of points that stay near the origin when
+// repeatedly raised to the power in spherical coordinates, with its poles on
+// the Y axis
+// The distance is only an estimate from how fast the points escape, and gets
+// more detailed the more iterations it's given
+func MandelbulbSDF(power float64, iterations int) SDF {
+	// Everything further out than this escapes
+	const bailout = 2
+
+	return SDF{
+		distance: func(p Vec3) float64 {
+			z := p
+			dr := 1.0
+			r := z.Length()
+
+			for range iterations {
+				if r > bailout {
+					break
+				}
+
+				// Raise z to the power, rotating it and scaling its length,
+				// and track how fast that stretches the space around it
+				theta := math.Acos(Interval{-1, 1}.Clamp(z.y/r)) * power
+				phi := math.Atan2(z.z, z.x) * power
+				dr = math.Pow(r, power-1)*power*dr + 1
+
+				sinTheta, cosTheta := math.Sincos(theta)
+				sinPhi, cosPhi := math.Sincos(phi)
+				z = Vec3{sinTheta * cosPhi, cosTheta, sinTheta * sinPhi}.Scale(math.Pow(r, power)).Add(p)
+				r = z.Length()
+			}
+
+			if r == 0 {
+				return 0
+			}
+			return 0.5 * math.Log(r) * r / dr
+		},
+		box: originBox(Vec3{bailout, bailout, bailout}),
+	}
+}
+
+// The shape moved by the offset
+func Moved(s SDF, offset Vec3) SDF {
+	return SDF{
+		distance: func(p Vec3) float64 {
+			return s.distance(p.Sub(offset))
+		},
+		box: AABB{min: s.box.min.Add(offset), max: s.box.max.Add(offset)},
+	}
+}
+
+// Two shapes merged together, blending into each other where they're within
+// smoothness of each other using a polynomial smooth minimum. A smoothness of
+// 0 joins them with a sharp crease
+func SmoothUnion(a SDF, b SDF, smoothness float64) SDF {
+	box := a.box.Union(b.box)
+
+	// The blend fills in the crease, but never by more than a quarter of
+	// the smoothness
+	pad := Vec3{smoothness, smoothness, smoothness}.Scale(0.25)
+
+	return SDF{
+		distance: func(p Vec3) float64 {
+			da, db := a.distance(p), b.distance(p)
+			if smoothness <= 0 {
+				return math.Min(da, db)
+			}
+
+			h := math.Max(smoothness-math.Abs(da-db), 0) / smoothness
+			return math.Min(da, db) - h*h*smoothness/4
+		},
+		box: AABB{min: box.min.Sub(pad), max: box.max.Add(pad)},
+	}
+}
+
+// The shape repeated forever, once every period along each axis, or not at all
+// along axes where the period is 0
+// The distance is only right when the shape fits inside its own period
+func Repeat(s SDF, period Vec3) SDF {
+	box := s.box
+	inf := math.Inf(1)
+	for axis := range 3 {
+		if period.Axis(axis) != 0 {
+			box.min = box.min.WithAxis(axis, -inf)
+			box.max = box.max.WithAxis(axis, inf)
+		}
+	}
+
+	return SDF{
+		distance: func(p Vec3) float64 {
+			for axis := range 3 {
+				if spacing := period.Axis(axis); spacing != 0 {
+					value := p.Axis(axis)
+					p = p.WithAxis(axis, value-spacing*math.Round(value/spacing))
+				}
+			}
+
+			return s.distance(p)
+		},
+		box: box,
+	}
+}
+
+// The shape twisted about the Y axis by rate radians for every unit up it
+func Twist(s SDF, rate float64) SDF {
+	// The twist sweeps the shape round the whole of the circle it fits in
+	radius := 0.0
+	for _, corner := range [4][2]float64{
+		{s.box.min.x, s.box.min.z},
+		{s.box.min.x, s.box.max.z},
+		{s.box.max.x, s.box.min.z},
+		{s.box.max.x, s.box.max.z},
+	} {
+		radius = math.Max(radius, math.Hypot(corner[0], corner[1]))
+	}
+
+	// Twisting stretches space sideways, further from the axis the more it
+	// does, so the distance is scaled down to never step past the surface
+	stretch := math.Sqrt(1 + rate*rate*radius*radius)
+
+	return SDF{
+		distance: func(p Vec3) float64 {
+			sin, cos := math.Sincos(-rate * p.y)
+			untwisted := Vec3{cos*p.x - sin*p.z, p.y, sin*p.x + cos*p.z}
+			return s.distance(untwisted) / stretch
+		},
+		box: AABB{min: Vec3{-radius, s.box.min.y, -radius}, max: Vec3{radius, s.box.max.y, radius}},
+	}
+}
+
+// The shape grown outwards by radius, rounding off its edges and corners
+func Round(s SDF, radius float64) SDF {
+	pad := Vec3{radius, radius, radius}
+
+	return SDF{
+		distance: func(p Vec3) float64 {
+			return s.distance(p) - radius
+		},
+		box: AABB{min: s.box.min.Sub(pad), max: s.box.max.Add(pad)},
+	}
+}
+
+// Implements Object interface
+// Implements Solid interface
+// A surface drawn by sphere tracing a signed distance function: rays step
+// forwards by the distance to the surface, which can never overshoot it, until
+// they come within epsilon of it or run out of steps. Normals come from the
+// central differences of the distance
+// SDF surfaces have no texture coordinates, so are best textured by solid
+// textures such as checkers and noise
+type SDFObject struct {
+	sdf      SDF
+	box      AABB
+	maxSteps int
+	epsilon  float64
+	material Material
+}
+
+// An object for the SDF within the box, which can cut a shape that goes on
+// forever down to size
+func NewSDFObject(sdf SDF, box AABB, maxSteps int, epsilon float64, material Material) *SDFObject {
+	// Leave room around the surface for rays to start marching before they
+	// reach it
+	margin := Vec3{epsilon, epsilon, epsilon}.Scale(10)
+
+	return &SDFObject{
+		sdf:      sdf,
+		box:      AABB{min: box.min.Sub(margin), max: box.max.Add(margin)},
+		maxSteps: maxSteps,
+		epsilon:  epsilon,
+		material: material,
+	}
+}
+
+func (s *SDFObject) Center() Vec3 {
+	return s.box.Centroid()
+}
+
+func (s *SDFObject) BoundingBox() AABB {
+	return s.box
+}
+
+// Rays leaving the surface, like reflections, start within epsilon of it, so
+// they have to step off it before looking for the next hit
+func (s *SDFObject) Hit(r Ray, itv Interval) (HitRecord, bool) {
+	return s.march(r, itv, math.Abs(s.sdf.distance(r.origin)) < s.epsilon)
+}
+
+// The spans are found by marching from each hit to the next, stepping off the
+// surface at the start of every search
+func (s *SDFObject) Spans(r Ray, itv Interval) []Span {
+	return crossingSpans(sdfCrossings{s}, r, itv)
+}
+
+// Step along the ray within the interval until it comes within epsilon of the
+// surface, first stepping off it if leaving
+func (s *SDFObject) march(r Ray, itv Interval, leaving bool) (HitRecord, bool) {
+	// Only march through the part of the ray inside the box
+	itv, ok := s.box.Clip(r, itv)
+	if !ok {
+		return HitRecord{}, false
+	}
+
+	// The direction isn't always unit length, so convert distances into
+	// steps along the ray
+	speed := r.direction.Length()
+	t := itv.min
+
+	for range s.maxSteps {
+		if t > itv.max {
+			break
+		}
+
+		// Marching by the size of the distance finds the way out of the
+		// shape too, for rays that start inside it
+		distance := math.Abs(s.sdf.distance(r.At(t)))
+		if distance < s.epsilon {
+			if !leaving {
+				return s.hitRecord(r, t), true
+			}
+			distance = s.epsilon
+		} else {
+			leaving = false
+		}
+
+		t += distance / speed
+	}
+
+	return HitRecord{}, false
+}
+
+// The SDF seen by crossingSpans, which looks for each crossing just past the
+// last one, so has to step off the surface where the search starts rather than
+// where the ray does
+type sdfCrossings struct {
+	*SDFObject
+}
+
+func (s sdfCrossings) Hit(r Ray, itv Interval) (HitRecord, bool) {
+	return s.march(r, itv, math.Abs(s.sdf.distance(r.At(itv.min))) < s.epsilon)
+}
+
+func (s *SDFObject) hitRecord(r Ray, t float64) HitRecord {
+	point := r.At(t)
+	normal := s.Normal(point)
+	dpdu, dpdv := normal.Basis()
+
+	return HitRecord{
+		t:               t,
+		point:           point,
+		normal:          normal,
+		geometricNormal: normal,
+		dpdu:            dpdu,
+		dpdv:            dpdv,
+		material:        s.material,
+		object:          s,
+	}
+}
+
+// The direction the distance grows fastest in at the point, found by central
+// differences epsilon apart
+func (s *SDFObject) Normal(point Vec3) Vec3 {
+	h := s.epsilon
+	normal := Vec3{
+		s.sdf.distance(point.Add(Vec3{h, 0, 0})) - s.sdf.distance(point.Sub(Vec3{h, 0, 0})),
+		s.sdf.distance(point.Add(Vec3{0, h, 0})) - s.sdf.distance(point.Sub(Vec3{0, h, 0})),
+		s.sdf.distance(point.Add(Vec3{0, 0, h})) - s.sdf.distance(point.Sub(Vec3{0, 0, h})),
+	}
+
+	if normal.LengthSquared() == 0 {
+		return Vec3{0, 1, 0}
+	}
+
+	return normal.Unit()
+}
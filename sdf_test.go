@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSDFHit(t *testing.T) {
+	ball := NewSDFObject(SphereSDF(1), SphereSDF(1).box, defaultSDFSteps, defaultSDFEpsilon, nil)
+	r := Ray{Vec3{0, 0, -5}, Vec3{0, 0, 1}}
+
+	tests := []struct {
+		name string
+		r    Ray
+		itv  Interval
+		want float64
+	}{
+		{"from outside", r, Interval{0, math.Inf(1)}, 4},
+
+		// The interval starting on the surface doesn't mean the ray is leaving
+		// it
+		{"interval starting on the surface", r, Interval{4, math.Inf(1)}, 4},
+		{"leaving the surface", Ray{Vec3{0, 0, -1}, Vec3{0, 0, 1}}, Interval{0.0001, math.Inf(1)}, 2},
+		{"from inside", Ray{Vec3{0, 0, 0}, Vec3{0, 0, 2}}, Interval{0, math.Inf(1)}, 0.5},
+	}
+
+	for _, test := range tests {
+		rec, ok := ball.Hit(test.r, test.itv)
+		if !ok {
+			t.Errorf("%s: missed", test.name)
+			continue
+		}
+		if math.Abs(rec.t-test.want) > 1e-3 {
+			t.Errorf("%s: hit at %v, want %v", test.name, rec.t, test.want)
+		}
+	}
+}
+
+func TestSDFSpans(t *testing.T) {
+	ball := NewSDFObject(SphereSDF(1), SphereSDF(1).box, defaultSDFSteps, defaultSDFEpsilon, nil)
+	r := Ray{Vec3{0, 0, -5}, Vec3{0, 0, 1}}
+
+	spans := ball.Spans(r, Interval{0, math.Inf(1)})
+	if len(spans) != 1 || math.Abs(spans[0].in.t-4) > 1e-3 || math.Abs(spans[0].out.t-6) > 1e-3 {
+		t.Fatalf("got spans %v, want one from 4 to 6", spanEnds(spans))
+	}
+
+	// Cutting a hole through the middle leaves the shell either side of it
+	hole := Sphere{Vec3{0, 0, 0}, 0.5, nil}
+	shell, ok := NewCSG(CSGDifference, ball, hole)
+	if !ok {
+		t.Fatal("an sdf should combine")
+	}
+
+	got := spanEnds(shell.Spans(r, Interval{0, math.Inf(1)}))
+	want := [][2]float64{{4, 4.5}, {5.5, 6}}
+	if len(got) != len(want) {
+		t.Fatalf("got spans %v, want %v", got, want)
+	}
+	for i := range got {
+		if math.Abs(got[i][0]-want[i][0]) > 1e-3 || math.Abs(got[i][1]-want[i][1]) > 1e-3 {
+			t.Errorf("got spans %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// Turns luminance in kcd/m² into the units light is rendered in, so that a
+// white surface lit by the midday sun comes out a little below white
+const skyUnits = 0.04
+
+// How far the sun's disk reaches from its center, in radians
+const sunAngularRadius = 0.00465
+
+// The luminance of the sun above the atmosphere, in kcd/m²
+const sunLuminance = 2.2e6
+
+// The size of the image the sky is drawn into so it can be importance sampled
+const (
+	skySampleWidth  = 256
+	skySampleHeight = 128
+)
+
+// Implements Light interface
+// Daylight from the analytic model in Preetham, Shirley and Smits' "A
+// Practical Analytic Model for Daylight", along with the sun's disk shining
+// through the same atmosphere. Turbidity is how hazy the air is, from 2 for a
+// clear day up to 10 for a murky one. Below the horizon the sky carries on
+// with its color at the horizon
+type PhysicalSky struct {
+	sunDirection Vec3
+	cosSunRadius float64
+	sun          Vec3 // The radiance of the sun's disk
+
+	zenith    [3]float64    // The x and y chromaticity and luminance straight up
+	perez     [3][5]float64 // The Perez function's coefficients for x, y and Y
+	intensity float64
+
+	// The sky drawn into an image, used to pick directions towards its
+	// brighter parts
+	distribution *Environment
+}
+
+// The sky with the sun at an elevation above the horizon and an azimuth round
+// from -Z towards +X, both in radians
+func NewPhysicalSky(elevation float64, azimuth float64, turbidity float64, intensity float64) *PhysicalSky {
+	cosElevation := math.Cos(elevation)
+	s := &PhysicalSky{
+		sunDirection: Vec3{math.Sin(azimuth) * cosElevation, math.Sin(elevation), -math.Cos(azimuth) * cosElevation},
+		cosSunRadius: math.Cos(sunAngularRadius),
+		intensity:    intensity,
+	}
+
+	// The angle of the sun from straight up
+	thetaS := math.Pi/2 - elevation
+	t := turbidity
+
+	chi := (4.0/9.0 - t/120) * (math.Pi - 2*thetaS)
+	s.zenith = [3]float64{
+		t*t*(0.00166*thetaS*thetaS*thetaS-0.00375*thetaS*thetaS+0.00209*thetaS) +
+			t*(-0.02903*thetaS*thetaS*thetaS+0.06377*thetaS*thetaS-0.03202*thetaS+0.00394) +
+			(0.11693*thetaS*thetaS*thetaS - 0.21196*thetaS*thetaS + 0.06052*thetaS + 0.25886),
+		t*t*(0.00275*thetaS*thetaS*thetaS-0.00610*thetaS*thetaS+0.00317*thetaS) +
+			t*(-0.04214*thetaS*thetaS*thetaS+0.08970*thetaS*thetaS-0.04153*thetaS+0.00516) +
+			(0.15346*thetaS*thetaS*thetaS - 0.26756*thetaS*thetaS + 0.06670*thetaS + 0.26688),
+		(4.0453*t-4.9710)*math.Tan(chi) - 0.2155*t + 2.4192,
+	}
+	s.perez = [3][5]float64{
+		{-0.0193*t - 0.2592, -0.0665*t + 0.0008, -0.0004*t + 0.2125, -0.0641*t - 0.8989, -0.0033*t + 0.0452},
+		{-0.0167*t - 0.2608, -0.0950*t + 0.0092, -0.0079*t + 0.2102, -0.0441*t - 1.6537, -0.0109*t + 0.0529},
+		{0.1787*t - 1.4630, -0.3554*t + 0.4275, -0.0227*t + 5.3251, 0.1206*t - 2.5771, -0.0670*t + 0.3703},
+	}
+
+	// The sun is dimmed by scattering off air molecules and haze, which takes
+	// more blue out the further the light travels through the atmosphere
+	thetaDegrees := thetaS * 180 / math.Pi
+	airMass := 1 / (math.Cos(thetaS) + 0.15*math.Pow(93.885-thetaDegrees, -1.253))
+	beta := 0.04608*t - 0.04586
+	transmittance := func(wavelength float64) float64 {
+		rayleigh := math.Exp(-0.008735 * math.Pow(wavelength, -4.08) * airMass)
+		aerosol := math.Exp(-beta * math.Pow(wavelength, -1.3) * airMass)
+		return rayleigh * aerosol
+	}
+	s.sun = Vec3{transmittance(0.65), transmittance(0.55), transmittance(0.45)}.Scale(sunLuminance * skyUnits)
+
+	// Draw the sky without the sun, which is sampled on its own
+	image := &ImageTexture{
+		width:  skySampleWidth,
+		height: skySampleHeight,
+		pixels: make([]Vec3, skySampleWidth*skySampleHeight),
+		wrap:   repeatWrap,
+	}
+	for y := range image.height {
+		for x := range image.width {
+			u := (float64(x) + 0.5) / float64(image.width)
+			v := (float64(y) + 0.5) / float64(image.height)
+			image.pixels[y*image.width+x] = s.skyRadiance(equirectangularDirection(u, v))
+		}
+	}
+	s.distribution = NewEnvironment(image, 0, 1)
+
+	return s
+}
+
+// The light arriving from a direction, including the sun
+func (s *PhysicalSky) Radiance(direction Vec3) Vec3 {
+	radiance := s.skyRadiance(direction)
+	if direction.Unit().Dot(s.sunDirection) >= s.cosSunRadius {
+		radiance = radiance.Add(s.sun.Scale(s.intensity))
+	}
+
+	return radiance
+}
+
+// The light scattered towards a direction by the sky, leaving out the sun
+func (s *PhysicalSky) skyRadiance(direction Vec3) Vec3 {
+	// Directions below the horizon see the sky just above it
+	d := direction.Unit()
+	d = Vec3{d.x, math.Max(d.y, 1e-3), d.z}.Unit()
+
+	cosGamma := Interval{-1, 1}.Clamp(d.Dot(s.sunDirection))
+	gamma := math.Acos(cosGamma)
+	thetaS := math.Acos(Interval{-1, 1}.Clamp(s.sunDirection.y))
+
+	// Each of x, y and Y is its value straight up scaled by how the Perez
+	// function changes between there and this direction
+	var xyY [3]float64
+	for i, c := range s.perez {
+		perez := func(cosTheta float64, gamma float64, cosGamma float64) float64 {
+			return (1 + c[0]*math.Exp(c[1]/cosTheta)) * (1 + c[2]*math.Exp(c[3]*gamma) + c[4]*cosGamma*cosGamma)
+		}
+		xyY[i] = s.zenith[i] * perez(d.y, gamma, cosGamma) / perez(1, thetaS, math.Cos(thetaS))
+	}
+
+	return xyYToRGB(xyY[0], xyY[1], xyY[2]).Scale(skyUnits * s.intensity)
+}
+
+// Convert a CIE xyY color to linear sRGB, leaving out colors it can't show
+func xyYToRGB(x float64, y float64, luminance float64) Vec3 {
+	if y <= 0 {
+		return Vec3{0, 0, 0}
+	}
+
+	X := x / y * luminance
+	Z := (1 - x - y) / y * luminance
+
+	return Vec3{
+		math.Max(0, 3.2404542*X-1.5371385*luminance-0.4985314*Z),
+		math.Max(0, -0.9692660*X+1.8760108*luminance+0.0415560*Z),
+		math.Max(0, 0.0556434*X-0.2040259*luminance+1.0572252*Z),
+	}
+}
+
+// The chance of aiming at the sun rather than the rest of the sky
+func (s *PhysicalSky) sunChance() float64 {
+	if s.sun == (Vec3{}) {
+		return 0
+	}
+
+	return 0.5
+}
+
+// Aim at either the sun's disk or the sky, in proportion to its brightness
+func (s *PhysicalSky) SampleDirection(origin Vec3, rng *rand.Rand) (Vec3, float64, bool) {
+	var direction Vec3
+	if rng.Float64() < s.sunChance() {
+		cosTheta := 1 - rng.Float64()*(1-s.cosSunRadius)
+		sinTheta := math.Sqrt(math.Max(0, 1-cosTheta*cosTheta))
+		phi := 2 * math.Pi * rng.Float64()
+
+		tangent, bitangent := s.sunDirection.Basis()
+		direction = Vec3{math.Cos(phi) * sinTheta, math.Sin(phi) * sinTheta, cosTheta}.FromBasis(tangent, bitangent, s.sunDirection)
+	} else {
+		var ok bool
+		if direction, _, ok = s.distribution.SampleDirection(origin, rng); !ok {
+			return Vec3{}, 0, false
+		}
+	}
+
+	pdf := s.DirectionPDF(origin, direction)
+	if pdf <= 0 {
+		return Vec3{}, 0, false
+	}
+
+	return direction, pdf, true
+}
+
+func (s *PhysicalSky) DirectionPDF(origin Vec3, direction Vec3) float64 {
+	sunChance := s.sunChance()
+	pdf := (1 - sunChance) * s.distribution.DirectionPDF(origin, direction)
+
+	if direction.Unit().Dot(s.sunDirection) >= s.cosSunRadius {
+		pdf += sunChance / (2 * math.Pi * (1 - s.cosSunRadius))
+	}
+
+	return pdf
+}
+
+// The sky is only seen by rays that miss everything
+func (s *PhysicalSky) Incoming(origin Vec3, direction Vec3) Vec3 {
+	if !escapes(origin, direction) {
+		return Vec3{0, 0, 0}
+	}
+
+	return s.Radiance(direction)
+}
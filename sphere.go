@@ -1,11 +1,9 @@
 package main
 
-import (
-	"image/color"
-	"math"
-)
+import "math"
 
 // Implements Object interface
+// Implements Solid interface
 type Sphere struct {
 	position Vec3
 	radius   float64
@@ -16,21 +14,8 @@ func (s Sphere) Center() Vec3 {
 	return s.position
 }
 
-func (s Sphere) Color() color.RGBA {
-	return s.material.color
-}
-
-func (s Sphere) Roughness() float64 {
-	return s.material.roughness
-}
-
-func (s Sphere) Transparency() float64 {
-	return s.material.transparency
-}
-
-// If the ray hits the sphere, return where along the ray it does so
-// If the ray does not hit the sphere, return -1
-func (s Sphere) Hit(r Ray, itv Interval) float64 {
+// If the ray hits the sphere within the interval, describe where it does so
+func (s Sphere) Hit(r Ray, itv Interval) (HitRecord, bool) {
 	// Get the distance vector from the origin of the ray to the center of the object
 	distance := r.origin.Sub(s.position)
 
@@ -44,7 +29,7 @@ func (s Sphere) Hit(r Ray, itv Interval) float64 {
 
 	// Check for a hit
 	if discriminant < 0 {
-		return -1
+		return HitRecord{}, false
 	}
 
 	root := (-halfB - math.Sqrt(discriminant)) / a
@@ -53,46 +38,79 @@ func (s Sphere) Hit(r Ray, itv Interval) float64 {
 	if !itv.Contains(root) {
 		root = (-halfB + math.Sqrt(discriminant)) / a
 		if !itv.Contains(root) {
-			return -1
+			return HitRecord{}, false
 		}
 	}
 
-	// Finish the quadratic formula
-	return root
+	normal := s.UnitNormal(r, root)
+	u, v := s.UV(r.At(root))
+	dpdu, dpdv := s.Derivatives(r.At(root))
+
+	return HitRecord{
+		t:               root,
+		point:           r.At(root),
+		normal:          normal,
+		geometricNormal: normal,
+		u:               u,
+		v:               v,
+		dpdu:            dpdu,
+		dpdv:            dpdv,
+		material:        s.material,
+		object:          s,
+	}, true
 }
 
-// The normal vector of the point where the ray hit the sphere
-func (s Sphere) Normal(r Ray, t float64) Vec3 {
-	return r.At(t).Sub(s.position)
+func (s Sphere) Spans(r Ray, itv Interval) []Span {
+	return crossingSpans(s, r, itv)
 }
 
-// The unit normal vector of the point where the ray hit the sphere
-func (s Sphere) UnitNormal(r Ray, t float64) Vec3 {
-	return s.Normal(r, t).Unit()
+// The texture coordinates of a point on the sphere, with u going once around
+// the Y axis starting from -X and v going from the bottom pole to the top one
+func (s Sphere) UV(point Vec3) (float64, float64) {
+	direction := point.Sub(s.position).Unit()
+	theta := math.Acos(Interval{-1, 1}.Clamp(-direction.y))
+	phi := math.Atan2(-direction.z, direction.x) + math.Pi
+
+	return phi / (2 * math.Pi), theta / math.Pi
 }
 
-// Calculate the refraction of a ray through the sphere
-func (s Sphere) Refract(direction Vec3, normal Vec3, hitFront bool) Vec3 {
-	// Calcluate the cosine of the angle between the two unit vectors
-	cosTheta := math.Min(direction.Unit().Dot(normal.Unit()), 1)
+// How a point on the sphere moves as its texture coordinates increase, with u
+// running around the Y axis and v running from pole to pole
+func (s Sphere) Derivatives(point Vec3) (Vec3, Vec3) {
+	radius := math.Abs(s.radius)
+	direction := point.Sub(s.position).Div(radius)
 
-	refractionIndex := s.material.refractionIndex
+	dpdu := Vec3{direction.z, 0, -direction.x}.Scale(2 * math.Pi * radius)
 
-	// Do we need to flip the refraction index to exit the material?
-	if hitFront {
-		refractionIndex = 1 / refractionIndex
+	// Every direction away from a pole heads towards the other one, so pick
+	// any of them
+	sinTheta := math.Sqrt(direction.x*direction.x + direction.z*direction.z)
+	if sinTheta < 1e-9 {
+		tangent, bitangent := direction.Basis()
+		return tangent, bitangent
 	}
 
-	// The perpendicular direction of the exit ray
-	exitPerpendicular := direction.
-		Add(normal.Scale(cosTheta)).
-		Scale(refractionIndex)
+	cosTheta := -direction.y
+	dpdv := Vec3{cosTheta * direction.x / sinTheta, sinTheta, cosTheta * direction.z / sinTheta}.Scale(math.Pi * radius)
+
+	return dpdu, dpdv
+}
 
-	// The parallel direction of the exit ray
-	exitParallel := normal.Scale(
-		-math.Sqrt(math.Abs(1 - exitPerpendicular.LengthSquared())),
-	)
+// The box that tightly contains the sphere
+func (s Sphere) BoundingBox() AABB {
+	r := math.Abs(s.radius)
+	extent := Vec3{r, r, r}
+	return AABB{min: s.position.Sub(extent), max: s.position.Add(extent)}
+}
 
-	// Add the perpendicular and parallel components of the exit ray
-	return exitParallel.Add(exitPerpendicular)
+// The normal vector of the point where the ray hit the sphere
+func (s Sphere) Normal(r Ray, t float64) Vec3 {
+	return r.At(t).Sub(s.position)
+}
+
+// The unit normal vector of the point where the ray hit the sphere
+// A negative radius turns the sphere inside out, so its normals point inwards
+// and it can make a hollow bubble inside another sphere
+func (s Sphere) UnitNormal(r Ray, t float64) Vec3 {
+	return s.Normal(r, t).Scale(1 / s.radius)
 }
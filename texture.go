@@ -0,0 +1,246 @@
+package main
+
+import (
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// How a property of a surface, such as its color, varies across it
+type Texture interface {
+	// The value at a point with texture coordinates u and v, as linear RGB
+	Value(u float64, v float64, point Vec3) Vec3
+}
+
+// Read a texture as a single number, such as a roughness, from its brightness
+func scalarValue(t Texture, hit HitRecord) float64 {
+	return luminance(t.Value(hit.u, hit.v, hit.point))
+}
+
+// Implements Texture interface
+// The same color everywhere
+type SolidColor struct {
+	color Vec3
+}
+
+func (s SolidColor) Value(u float64, v float64, point Vec3) Vec3 {
+	return s.color
+}
+
+// Implements Texture interface
+// Alternating cubes of two textures filling space, each scale units wide
+type Checker struct {
+	scale float64
+	even  Texture
+	odd   Texture
+}
+
+func (c Checker) Value(u float64, v float64, point Vec3) Vec3 {
+	cell := math.Floor(point.x/c.scale) + math.Floor(point.y/c.scale) + math.Floor(point.z/c.scale)
+	if int(cell)%2 == 0 {
+		return c.even.Value(u, v, point)
+	}
+
+	return c.odd.Value(u, v, point)
+}
+
+// Maps a texel coordinate that may be outside an image of size n back into it
+type WrapMode func(i int, n int) int
+
+// The wrap modes that can be picked by name
+var wrapModes = map[string]WrapMode{
+	"repeat": repeatWrap,
+	"clamp":  clampWrap,
+	"mirror": mirrorWrap,
+}
+
+// Tile the image
+func repeatWrap(i int, n int) int {
+	return ((i % n) + n) % n
+}
+
+// Stretch the edges of the image
+func clampWrap(i int, n int) int {
+	return min(max(i, 0), n-1)
+}
+
+// Tile the image, flipping every other copy so the edges line up
+func mirrorWrap(i int, n int) int {
+	i = repeatWrap(i, 2*n)
+	if i >= n {
+		return 2*n - 1 - i
+	}
+
+	return i
+}
+
+// Implements Texture interface
+// An image stretched over the 0 to 1 texture coordinates, with v = 0 at the
+// bottom of the image, blending between the nearest four pixels
+type ImageTexture struct {
+	width  int
+	height int
+	pixels []Vec3 // Linear RGB, row by row from the top
+	wrap   WrapMode
+}
+
+// Load a PNG, JPEG, Radiance HDR or PFM image as a texture. Images holding
+// colors are stored as sRGB, while linear ones hold data such as roughness as
+// it is. HDR and PFM images are always linear
+func loadImageTexture(path string, linear bool, wrap WrapMode) (*ImageTexture, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".hdr", ".pfm":
+		return loadHDRTexture(path, wrap)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	texture := &ImageTexture{
+		width:  bounds.Dx(),
+		height: bounds.Dy(),
+		pixels: make([]Vec3, 0, bounds.Dx()*bounds.Dy()),
+		wrap:   wrap,
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			rgb := Vec3{float64(r), float64(g), float64(b)}.Div(math.MaxUint16)
+			if !linear {
+				rgb = linearFromSRGB(rgb)
+			}
+			texture.pixels = append(texture.pixels, rgb)
+		}
+	}
+
+	return texture, nil
+}
+
+func (t *ImageTexture) Value(u float64, v float64, point Vec3) Vec3 {
+	// Pixel centers are half a pixel in from the edges
+	x := u*float64(t.width) - 0.5
+	y := (1-v)*float64(t.height) - 0.5
+
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+
+	top := t.texel(int(x0), int(y0)).Scale(1 - fx).Add(t.texel(int(x0)+1, int(y0)).Scale(fx))
+	bottom := t.texel(int(x0), int(y0)+1).Scale(1 - fx).Add(t.texel(int(x0)+1, int(y0)+1).Scale(fx))
+
+	return top.Scale(1 - fy).Add(bottom.Scale(fy))
+}
+
+// The pixel at x, y after wrapping them into the image
+func (t *ImageTexture) texel(x int, y int) Vec3 {
+	return t.pixels[t.wrap(y, t.height)*t.width+t.wrap(x, t.width)]
+}
+
+// Ken Perlin's gradient noise, which varies smoothly between -1 and 1 with
+// features about one unit apart
+type Perlin struct {
+	gradients [256]Vec3
+	permX     [256]int
+	permY     [256]int
+	permZ     [256]int
+}
+
+// Noise with its own random gradients, so different seeds look different
+func NewPerlin(seed uint64) *Perlin {
+	rng := rand.New(rand.NewPCG(seed, 0))
+	p := &Perlin{}
+
+	for i := range p.gradients {
+		p.gradients[i] = randomRangeVec3(rng, -1, 1).Unit()
+	}
+	copy(p.permX[:], rng.Perm(256))
+	copy(p.permY[:], rng.Perm(256))
+	copy(p.permZ[:], rng.Perm(256))
+
+	return p
+}
+
+// The noise at a point
+func (p *Perlin) Noise(point Vec3) float64 {
+	fx, fy, fz := math.Floor(point.x), math.Floor(point.y), math.Floor(point.z)
+	x, y, z := int(fx), int(fy), int(fz)
+	offset := Vec3{point.x - fx, point.y - fy, point.z - fz}
+
+	// Smooth the interpolation so the noise has no creases at cell edges
+	u := offset.x * offset.x * (3 - 2*offset.x)
+	v := offset.y * offset.y * (3 - 2*offset.y)
+	w := offset.z * offset.z * (3 - 2*offset.z)
+
+	sum := 0.0
+	for i := range 2 {
+		for j := range 2 {
+			for k := range 2 {
+				gradient := p.gradients[p.permX[(x+i)&255]^p.permY[(y+j)&255]^p.permZ[(z+k)&255]]
+				weight := Vec3{offset.x - float64(i), offset.y - float64(j), offset.z - float64(k)}
+
+				sum += (float64(i)*u + float64(1-i)*(1-u)) *
+					(float64(j)*v + float64(1-j)*(1-v)) *
+					(float64(k)*w + float64(1-k)*(1-w)) *
+					gradient.Dot(weight)
+			}
+		}
+	}
+
+	return sum
+}
+
+// Several octaves of noise added together, each at twice the frequency and
+// half the strength of the last, giving a value from 0 upwards
+func (p *Perlin) Turbulence(point Vec3, octaves int) float64 {
+	sum := 0.0
+	weight := 1.0
+
+	for range octaves {
+		sum += weight * p.Noise(point)
+		weight /= 2
+		point = point.Scale(2)
+	}
+
+	return math.Abs(sum)
+}
+
+// Implements Texture interface
+// Turbulent noise shading its color from black up to full strength
+type Turbulence struct {
+	noise   *Perlin
+	scale   float64 // How many features fit in one unit
+	octaves int
+	color   Vec3
+}
+
+func (t Turbulence) Value(u float64, v float64, point Vec3) Vec3 {
+	return t.color.Scale(math.Min(1, t.noise.Turbulence(point.Scale(t.scale), t.octaves)))
+}
+
+// Implements Texture interface
+// Bands of its color running along z, bent into veins by turbulent noise
+type Marble struct {
+	noise   *Perlin
+	scale   float64 // How many bands fit in one unit
+	octaves int
+	color   Vec3
+}
+
+func (m Marble) Value(u float64, v float64, point Vec3) Vec3 {
+	phase := m.scale*point.z + 10*m.noise.Turbulence(point, m.octaves)
+	return m.color.Scale((1 + math.Sin(phase)) / 2)
+}
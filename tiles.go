@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math/rand/v2"
+	"runtime"
+	"sync"
+)
+
+// The width and height of the square tiles the frame is split into
+const tileSize = 32
+
+// A rectangle of pixels rendered as one unit of work
+type tile struct {
+	index  int // The position of the tile in the frame, used to seed its randomness
+	x0, y0 int // Inclusive top left pixel
+	x1, y1 int // Exclusive bottom right pixel
+}
+
+// Cut the frame into tiles, in reading order
+func splitTiles(width int, height int) []tile {
+	tiles := make([]tile, 0, ((width+tileSize-1)/tileSize)*((height+tileSize-1)/tileSize))
+
+	for y := 0; y < height; y += tileSize {
+		for x := 0; x < width; x += tileSize {
+			tiles = append(tiles, tile{
+				index: len(tiles),
+				x0:    x,
+				y0:    y,
+				x1:    min(x+tileSize, width),
+				y1:    min(y+tileSize, height),
+			})
+		}
+	}
+
+	return tiles
+}
+
+// The number of goroutines used to render a frame
+func workerCount() int {
+	if renderWorkers > 0 {
+		return renderWorkers
+	}
+
+	return runtime.GOMAXPROCS(0)
+}
+
+// Render every tile of the frame on a pool of workers, returning once they
+// are all done
+// Each tile's random source is seeded from the seed and the tile's index, so
+// the result doesn't depend on how many workers there are or which of them
+// renders which tile
+func renderTiles(width int, height int, seed uint64, renderTile func(t tile, rng *rand.Rand)) {
+	tiles := splitTiles(width, height)
+	queue := make(chan tile, len(tiles))
+	for _, t := range tiles {
+		queue <- t
+	}
+	close(queue)
+
+	var wg sync.WaitGroup
+	for range min(workerCount(), len(tiles)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Every worker has its own random source, reseeded for each tile
+			source := rand.NewPCG(0, 0)
+			rng := rand.New(source)
+
+			for t := range queue {
+				source.Seed(seed, uint64(t.index))
+				renderTile(t, rng)
+			}
+		}()
+	}
+	wg.Wait()
+}
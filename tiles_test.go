@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRenderTilesCoversFrame(t *testing.T) {
+	const width, height = 70, 45
+
+	keepSettings(t)
+
+	var covered [width * height]atomic.Int32
+	renderWorkers = 3
+	renderTiles(width, height, 0, func(t tile, rng *rand.Rand) {
+		for y := t.y0; y < t.y1; y++ {
+			for x := t.x0; x < t.x1; x++ {
+				covered[y*width+x].Add(1)
+			}
+		}
+	})
+
+	for i := range covered {
+		if count := covered[i].Load(); count != 1 {
+			t.Fatalf("pixel %d, %d rendered %d times", i%width, i/width, count)
+		}
+	}
+}
+
+func TestRenderIgnoresWorkerCount(t *testing.T) {
+	keepSettings(t)
+
+	scene, err := loadScene("scenes/cornell-box.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyScene(scene, nil)
+
+	// A frame that doesn't split evenly into tiles
+	screenWidth, screenHeight = 70, 45
+	world = NewBVH(objects, false)
+	lights = collectLights(objects)
+
+	render := func(workers int) *Film {
+		renderWorkers = workers
+		film := NewFilm(screenWidth, screenHeight, createCamera())
+		film.AddPass(2)
+		film.AddPass(2)
+		return film
+	}
+
+	one, many := render(1), render(5)
+
+	for y := range screenHeight {
+		for x := range screenWidth {
+			if a, b := one.Pixel(x, y), many.Pixel(x, y); a != b {
+				t.Fatalf("pixel %d, %d is %v with one worker and %v with five", x, y, a, b)
+			}
+		}
+	}
+}
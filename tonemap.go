@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Squeezes linear HDR colors into the range a display can show
+type ToneMap func(rgb Vec3) Vec3
+
+// The tone mapping operators that can be picked by name
+var toneMaps = map[string]ToneMap{
+	"clamp":             clampToneMap,
+	"reinhard":          reinhardToneMap,
+	"extended-reinhard": extendedReinhardToneMap,
+	"aces":              acesToneMap,
+	"hable":             hableToneMap,
+}
+
+// The names of the tone mapping operators, for messages
+func toneMapNames() string {
+	names := make([]string, 0, len(toneMaps))
+	for name := range toneMaps {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return strings.Join(names, ", ")
+}
+
+func checkToneMap(name string) error {
+	if _, ok := toneMaps[name]; !ok {
+		return fmt.Errorf("unknown tone map %q (choose from %s)", name, toneMapNames())
+	}
+
+	return nil
+}
+
+// The relative luminance of a linear RGB color
+func luminance(rgb Vec3) float64 {
+	return 0.2126*rgb.x + 0.7152*rgb.y + 0.0722*rgb.z
+}
+
+// Change the luminance of a color without changing its hue
+func withLuminance(rgb Vec3, l float64) Vec3 {
+	old := luminance(rgb)
+	if old <= 0 {
+		return Vec3{}
+	}
+
+	return rgb.Scale(l / old)
+}
+
+// Leave the color alone, so anything too bright is clipped
+func clampToneMap(rgb Vec3) Vec3 {
+	return rgb
+}
+
+// Reinhard's operator, applied to luminance so that colors keep their hue
+// Nothing ever quite reaches white
+func reinhardToneMap(rgb Vec3) Vec3 {
+	l := luminance(rgb)
+	return withLuminance(rgb, l/(1+l))
+}
+
+// Reinhard's operator extended so that luminance at the white point maps to
+// white
+func extendedReinhardToneMap(rgb Vec3) Vec3 {
+	l := luminance(rgb)
+	return withLuminance(rgb, l*(1+l/(whitePoint*whitePoint))/(1+l))
+}
+
+// Krzysztof Narkowicz's fit of the ACES filmic curve
+func acesToneMap(rgb Vec3) Vec3 {
+	curve := func(x float64) float64 {
+		return (x * (2.51*x + 0.03)) / (x*(2.43*x+0.59) + 0.14)
+	}
+
+	return Vec3{curve(rgb.x), curve(rgb.y), curve(rgb.z)}
+}
+
+// John Hable's filmic curve from Uncharted 2
+func hableToneMap(rgb Vec3) Vec3 {
+	const (
+		shoulderStrength = 0.15
+		linearStrength   = 0.5
+		linearAngle      = 0.1
+		toeStrength      = 0.2
+		toeNumerator     = 0.02
+		toeDenominator   = 0.3
+		exposureBias     = 2
+		linearWhite      = 11.2
+	)
+
+	curve := func(x float64) float64 {
+		a, b, c, d, e, f := shoulderStrength, linearStrength, linearAngle, toeStrength, toeNumerator, toeDenominator
+		return (x*(a*x+c*b)+d*e)/(x*(a*x+b)+d*f) - e/f
+	}
+
+	scale := 1 / curve(linearWhite)
+	return Vec3{
+		curve(rgb.x*exposureBias) * scale,
+		curve(rgb.y*exposureBias) * scale,
+		curve(rgb.z*exposureBias) * scale,
+	}
+}
+
+// Encode a linear value from 0 to 1 with the sRGB transfer function
+func linearToSRGB(linear float64) float64 {
+	if linear <= 0.0031308 {
+		return 12.92 * linear
+	}
+
+	return 1.055*math.Pow(linear, 1/2.4) - 0.055
+}
+
+// Decode an sRGB value from 0 to 1 into a linear one
+func sRGBToLinear(encoded float64) float64 {
+	if encoded <= 0.04045 {
+		return encoded / 12.92
+	}
+
+	return math.Pow((encoded+0.055)/1.055, 2.4)
+}
+
+// Decode an sRGB color with channels from 0 to 1 into linear RGB
+func linearFromSRGB(rgb Vec3) Vec3 {
+	return Vec3{sRGBToLinear(rgb.x), sRGBToLinear(rgb.y), sRGBToLinear(rgb.z)}
+}
+
+// Decode an 8 bit sRGB color into linear RGB from 0 to 1
+func linearFromRGBA(c color.RGBA) Vec3 {
+	return Vec3{
+		sRGBToLinear(float64(c.R) / float64(maxColorVal)),
+		sRGBToLinear(float64(c.G) / float64(maxColorVal)),
+		sRGBToLinear(float64(c.B) / float64(maxColorVal)),
+	}
+}
+
+// Turn a linear HDR color into one for the display: apply the exposure, tone
+// map it, clip what's left over and encode it as 8 bit sRGB
+func displayColor(rgb Vec3) color.RGBA {
+	rgb = toneMaps[toneMapName](rgb.Scale(math.Exp2(exposure)))
+
+	encode := func(linear float64) uint8 {
+		return uint8(math.Round(linearToSRGB(Interval{0, 1}.Clamp(linear)) * float64(maxColorVal)))
+	}
+
+	return color.RGBA{encode(rgb.x), encode(rgb.y), encode(rgb.z), maxColorVal}
+}
@@ -0,0 +1,94 @@
+package main
+
+import "math"
+
+// Implements Object interface
+// Implements Solid interface
+// A ring around its axis, made of a tube of minorRadius swept around a circle
+// of majorRadius. Texture coordinates wrap around the axis the same way as a
+// sphere's for u, with v going once around the tube starting from its inside
+type Torus struct {
+	frame       axisFrame
+	majorRadius float64
+	minorRadius float64
+	material    Material
+}
+
+func NewTorus(center Vec3, axis Vec3, majorRadius float64, minorRadius float64, material Material) Torus {
+	return Torus{
+		frame:       newAxisFrame(center, axis),
+		majorRadius: majorRadius,
+		minorRadius: minorRadius,
+		material:    material,
+	}
+}
+
+func (t Torus) Center() Vec3 {
+	return t.frame.origin
+}
+
+// The box around the circle through the middle of the tube, grown by the
+// tube's radius in every direction
+func (t Torus) BoundingBox() AABB {
+	ring := NewDisk(t.frame.origin, t.frame.y, t.majorRadius, nil).BoundingBox()
+	extent := Vec3{t.minorRadius, t.minorRadius, t.minorRadius}
+
+	return AABB{min: ring.min.Sub(extent), max: ring.max.Add(extent)}
+}
+
+func (t Torus) Hit(r Ray, itv Interval) (HitRecord, bool) {
+	local := t.frame.localRay(r)
+
+	// Solve along a unit direction, starting from the point on the ray
+	// closest to the center. Keeping the numbers small like this stops the
+	// quartic losing its precision when the ray starts far away
+	length := local.direction.Length()
+	d := local.direction.Div(length)
+	shift := -local.origin.Dot(d)
+	o := local.origin.Add(d.Scale(shift))
+
+	// Points on the torus satisfy
+	// (x² + y² + z² + R² - r²)² = 4R²(x² + z²)
+	R2 := t.majorRadius * t.majorRadius
+	g := o.Dot(o) + R2 - t.minorRadius*t.minorRadius
+	f := o.Dot(d)
+
+	roots := solveQuartic(
+		1,
+		4*f,
+		4*f*f+2*g-4*R2*(d.x*d.x+d.z*d.z),
+		4*f*g-8*R2*(o.x*d.x+o.z*d.z),
+		g*g-4*R2*(o.x*o.x+o.z*o.z),
+	)
+
+	for _, root := range roots {
+		hitT := (root + shift) / length
+		if !itv.Contains(hitT) {
+			continue
+		}
+
+		p := local.At(hitT)
+
+		// The normal points away from the nearest point on the circle through
+		// the middle of the tube
+		ring := math.Sqrt(p.x*p.x + p.z*p.z)
+		if ring == 0 {
+			continue
+		}
+		outward := Vec3{p.x / ring, 0, p.z / ring}
+		normal := p.Sub(outward.Scale(t.majorRadius))
+
+		// The angle around the tube, starting from the inside of the ring
+		phi := math.Atan2(p.y, ring-t.majorRadius)
+		v := (phi + math.Pi) / (2 * math.Pi)
+		dpdv := outward.Scale(-p.y).Add(Vec3{0, ring - t.majorRadius, 0}).Scale(2 * math.Pi)
+
+		return t.frame.hitRecord(r, hitT, normal, aroundAxis(p), v, aroundAxisDerivative(p), dpdv, t.material, t), true
+	}
+
+	return HitRecord{}, false
+}
+
+func (t Torus) Spans(r Ray, itv Interval) []Span {
+	return crossingSpans(t, r, itv)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// An affine transform together with its inverse, worked out once and shared
+// by everything using the transform
+type Transform struct {
+	matrix      Matrix // Object space to world space
+	inverse     Matrix // World space to object space
+	determinant float64
+}
+
+// The transform for the matrix, or false if it can't be undone
+func NewTransform(matrix Matrix) (*Transform, bool) {
+	inverse, ok := matrix.Inverse()
+	if !ok {
+		return nil, false
+	}
+
+	return &Transform{matrix: matrix, inverse: inverse, determinant: matrix.Determinant()}, true
+}
+
+// Implements Object interface
+// Implements Light interface
+// Implements Solid interface
+// An object moved, turned and resized by a transform without being changed
+// itself, so many instances can share one copy of the object
+// Rays are carried into the object's space to be intersected, and the hit is
+// carried back. The object's lights still work, since sampling happens in
+// object space and the density is corrected for how the transform stretches
+// directions
+type Transformed struct {
+	object    Object
+	transform *Transform
+}
+
+func NewTransformed(object Object, transform *Transform) Transformed {
+	return Transformed{object: object, transform: transform}
+}
+
+func (t Transformed) Center() Vec3 {
+	return t.transform.matrix.Point(t.object.Center())
+}
+
+// The box around the corners of the object's box once they're transformed
+func (t Transformed) BoundingBox() AABB {
+	box := t.object.BoundingBox()
+	if !box.Bounded() {
+		// Objects that go on forever still go on forever
+		inf := math.Inf(1)
+		return AABB{min: Vec3{-inf, -inf, -inf}, max: Vec3{inf, inf, inf}}
+	}
+
+	transformed := EmptyAABB()
+	for i := range 8 {
+		corner := box.min
+		if i&1 != 0 {
+			corner.x = box.max.x
+		}
+		if i&2 != 0 {
+			corner.y = box.max.y
+		}
+		if i&4 != 0 {
+			corner.z = box.max.z
+		}
+		transformed = transformed.Extend(t.transform.matrix.Point(corner))
+	}
+
+	return transformed
+}
+
+func (t Transformed) Hit(r Ray, itv Interval) (HitRecord, bool) {
+	rec, ok := t.object.Hit(t.localRay(r), itv)
+	if !ok {
+		return HitRecord{}, false
+	}
+
+	return t.worldHit(r, rec), true
+}
+
+func (t Transformed) Spans(r Ray, itv Interval) []Span {
+	solid, ok := t.object.(Solid)
+	if !ok {
+		return nil
+	}
+
+	spans := solid.Spans(t.localRay(r), itv)
+	for i := range spans {
+		spans[i].in = t.worldHit(r, spans[i].in)
+		spans[i].out = t.worldHit(r, spans[i].out)
+	}
+
+	return spans
+}
+
+// The ray in object space. The direction isn't normalized, so distances along
+// the ray stay the same in both spaces
+func (t Transformed) localRay(r Ray) Ray {
+	return Ray{t.transform.inverse.Point(r.origin), t.transform.inverse.Direction(r.direction)}
+}
+
+// Carry a hit on the object along the ray back into world space
+func (t Transformed) worldHit(r Ray, rec HitRecord) HitRecord {
+	// The open ends of spans aren't anywhere
+	if math.IsInf(rec.t, 0) {
+		return rec
+	}
+
+	rec.point = r.At(rec.t)
+	rec.normal = t.transform.inverse.TransposeDirection(rec.normal).Unit()
+	rec.geometricNormal = t.transform.inverse.TransposeDirection(rec.geometricNormal).Unit()
+	rec.dpdu = t.transform.matrix.Direction(rec.dpdu)
+	rec.dpdv = t.transform.matrix.Direction(rec.dpdv)
+
+	// Wrap the primitive that was hit, so it can be matched against the
+	// transformed lights
+	rec.object = Transformed{object: rec.object, transform: t.transform}
+
+	return rec
+}
+
+// How much denser directions in world space are than the object space
+// direction they came from, which is unit length
+func (t Transformed) directionDensity(localDirection Vec3) float64 {
+	length := t.transform.matrix.Direction(localDirection).Length()
+	return length * length * length / math.Abs(t.transform.determinant)
+}
+
+func (t Transformed) SampleDirection(origin Vec3, rng *rand.Rand) (Vec3, float64, bool) {
+	light, ok := t.object.(Light)
+	if !ok {
+		return Vec3{}, 0, false
+	}
+
+	localDirection, pdf, ok := light.SampleDirection(t.transform.inverse.Point(origin), rng)
+	if !ok {
+		return Vec3{}, 0, false
+	}
+
+	direction := t.transform.matrix.Direction(localDirection).Unit()
+	return direction, pdf * t.directionDensity(localDirection), true
+}
+
+func (t Transformed) DirectionPDF(origin Vec3, direction Vec3) float64 {
+	light, ok := t.object.(Light)
+	if !ok {
+		return 0
+	}
+
+	localDirection := t.transform.inverse.Direction(direction).Unit()
+	return light.DirectionPDF(t.transform.inverse.Point(origin), localDirection) * t.directionDensity(localDirection)
+}
+
+func (t Transformed) Incoming(origin Vec3, direction Vec3) Vec3 {
+	return surfaceIncoming(t, origin, direction)
+}
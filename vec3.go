@@ -35,6 +35,16 @@ func (v Vec3) Mul(c float64) Vec3 {
 	return v.Scale(c)
 }
 
+// Multiply each component by the matching component of the other vector,
+// which is how colors filter each other
+func (v Vec3) MulVec(v2 Vec3) Vec3 {
+	return Vec3{
+		x: v.x * v2.x,
+		y: v.y * v2.y,
+		z: v.z * v2.z,
+	}
+}
+
 func (v Vec3) Div(denom float64) Vec3 {
 	return v.Scale(1 / denom)
 }
@@ -81,3 +91,60 @@ func (v Vec3) OnPlane(v2 Vec3) bool {
 func (v Vec3) Reflect(normal Vec3) Vec3 {
 	return v.Sub(normal.Scale(2 * v.Dot(normal)))
 }
+
+// Get a component of the vector by its axis index (0 = x, 1 = y, 2 = z)
+func (v Vec3) Axis(axis int) float64 {
+	switch axis {
+	case 0:
+		return v.x
+	case 1:
+		return v.y
+	}
+	return v.z
+}
+
+// The vector with a component replaced by its axis index (0 = x, 1 = y, 2 = z)
+func (v Vec3) WithAxis(axis int, value float64) Vec3 {
+	switch axis {
+	case 0:
+		v.x = value
+	case 1:
+		v.y = value
+	default:
+		v.z = value
+	}
+	return v
+}
+
+// The component-wise minimum of two vectors
+func (v Vec3) Min(v2 Vec3) Vec3 {
+	return Vec3{math.Min(v.x, v2.x), math.Min(v.y, v2.y), math.Min(v.z, v2.z)}
+}
+
+// The component-wise maximum of two vectors
+func (v Vec3) Max(v2 Vec3) Vec3 {
+	return Vec3{math.Max(v.x, v2.x), math.Max(v.y, v2.y), math.Max(v.z, v2.z)}
+}
+
+// Build two unit vectors that form an orthonormal basis with the unit vector
+func (v Vec3) Basis() (Vec3, Vec3) {
+	// Cross with whichever axis is furthest from parallel to the vector
+	axis := Vec3{1, 0, 0}
+	if math.Abs(v.x) > 0.9 {
+		axis = Vec3{0, 1, 0}
+	}
+
+	tangent := v.Cross(axis).Unit()
+	return tangent, v.Cross(tangent)
+}
+
+// Express a vector given in the basis (tangent, bitangent, normal) in world
+// space
+func (v Vec3) FromBasis(tangent Vec3, bitangent Vec3, normal Vec3) Vec3 {
+	return tangent.Scale(v.x).Add(bitangent.Scale(v.y)).Add(normal.Scale(v.z))
+}
+
+// Express a world space vector in the basis (tangent, bitangent, normal)
+func (v Vec3) ToBasis(tangent Vec3, bitangent Vec3, normal Vec3) Vec3 {
+	return Vec3{v.Dot(tangent), v.Dot(bitangent), v.Dot(normal)}
+}